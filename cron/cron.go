@@ -0,0 +1,197 @@
+// Package cron parses standard five-field cron expressions
+// ("minute hour day-of-month month day-of-week") and computes their next
+// occurrence in a given time zone.
+package cron
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Schedule is a parsed cron expression.
+type Schedule struct {
+	minute, hour, dom, month, dow [61]bool
+	// domStar and dowStar record a day field starting with "*" or "?",
+	// stepped ones like "*/2" included. When both day fields are restricted
+	// otherwise a day matches if either of them does, as in Vixie cron.
+	domStar, dowStar bool
+}
+
+type bounds struct {
+	min, max int
+	names    map[string]int
+}
+
+var (
+	minutes = bounds{0, 59, nil}
+	hours   = bounds{0, 23, nil}
+	doms    = bounds{1, 31, nil}
+	months  = bounds{1, 12, map[string]int{
+		"jan": 1, "feb": 2, "mar": 3, "apr": 4, "may": 5, "jun": 6,
+		"jul": 7, "aug": 8, "sep": 9, "oct": 10, "nov": 11, "dec": 12,
+	}}
+	dows = bounds{0, 7, map[string]int{
+		"sun": 0, "mon": 1, "tue": 2, "wed": 3, "thu": 4, "fri": 5, "sat": 6,
+	}}
+)
+
+var macros = map[string]string{
+	"@yearly":   "0 0 1 1 *",
+	"@annually": "0 0 1 1 *",
+	"@monthly":  "0 0 1 * *",
+	"@weekly":   "0 0 * * 0",
+	"@daily":    "0 0 * * *",
+	"@midnight": "0 0 * * *",
+	"@hourly":   "0 * * * *",
+}
+
+// Parse reads a five-field expression or one of the @yearly, @monthly,
+// @weekly, @daily and @hourly shorthands. Fields accept *, lists (1,15),
+// ranges (1-5), steps (*/15, 8-18/2) and month or weekday names.
+func Parse(expr string) (*Schedule, error) {
+	expr = strings.TrimSpace(expr)
+	if macro, ok := macros[strings.ToLower(expr)]; ok {
+		expr = macro
+	}
+
+	fields := strings.Fields(expr)
+	if len(fields) != 5 {
+		return nil, fmt.Errorf("cron: expected 5 fields, got %d", len(fields))
+	}
+
+	s := &Schedule{}
+	var err error
+	if err = parseField(fields[0], minutes, &s.minute); err != nil {
+		return nil, err
+	}
+	if err = parseField(fields[1], hours, &s.hour); err != nil {
+		return nil, err
+	}
+	if err = parseField(fields[2], doms, &s.dom); err != nil {
+		return nil, err
+	}
+	if err = parseField(fields[3], months, &s.month); err != nil {
+		return nil, err
+	}
+	if err = parseField(fields[4], dows, &s.dow); err != nil {
+		return nil, err
+	}
+	if s.dow[7] {
+		s.dow[0] = true
+	}
+	s.domStar = strings.HasPrefix(fields[2], "*") || strings.HasPrefix(fields[2], "?")
+	s.dowStar = strings.HasPrefix(fields[4], "*") || strings.HasPrefix(fields[4], "?")
+	return s, nil
+}
+
+func parseField(field string, b bounds, set *[61]bool) error {
+	for _, part := range strings.Split(field, ",") {
+		rangePart, stepPart, hasStep := strings.Cut(part, "/")
+
+		step := 1
+		if hasStep {
+			n, err := strconv.Atoi(stepPart)
+			if err != nil || n < 1 {
+				return fmt.Errorf("cron: invalid step in %q", part)
+			}
+			step = n
+		}
+
+		var lo, hi int
+		switch {
+		case rangePart == "*" || rangePart == "?":
+			lo, hi = b.min, b.max
+		case strings.Contains(rangePart, "-"):
+			start, end, _ := strings.Cut(rangePart, "-")
+			var err error
+			if lo, err = parseValue(start, b); err != nil {
+				return err
+			}
+			if hi, err = parseValue(end, b); err != nil {
+				return err
+			}
+		default:
+			v, err := parseValue(rangePart, b)
+			if err != nil {
+				return err
+			}
+			lo, hi = v, v
+			if hasStep {
+				hi = b.max
+			}
+		}
+		if lo > hi {
+			return fmt.Errorf("cron: invalid range %q", part)
+		}
+
+		for v := lo; v <= hi; v += step {
+			set[v] = true
+		}
+	}
+	return nil
+}
+
+func parseValue(s string, b bounds) (int, error) {
+	if v, ok := b.names[strings.ToLower(s)]; ok {
+		return v, nil
+	}
+	v, err := strconv.Atoi(s)
+	if err != nil || v < b.min || v > b.max {
+		return 0, fmt.Errorf("cron: value %q out of range %d-%d", s, b.min, b.max)
+	}
+	return v, nil
+}
+
+// Next returns the first occurrence strictly after t, in t's location, or
+// the zero time if there is none within the next five years (e.g. "0 0 30 2 *").
+func (s *Schedule) Next(t time.Time) time.Time {
+	loc := t.Location()
+	t = t.Truncate(time.Minute).Add(time.Minute)
+	yearLimit := t.Year() + 5
+
+wrap:
+	if t.Year() > yearLimit {
+		return time.Time{}
+	}
+
+	for !s.month[int(t.Month())] {
+		t = time.Date(t.Year(), t.Month()+1, 1, 0, 0, 0, 0, loc)
+		if t.Month() == time.January {
+			goto wrap
+		}
+	}
+
+	for !s.dayMatches(t) {
+		t = time.Date(t.Year(), t.Month(), t.Day()+1, 0, 0, 0, 0, loc)
+		if t.Day() == 1 {
+			goto wrap
+		}
+	}
+
+	for !s.hour[t.Hour()] {
+		t = time.Date(t.Year(), t.Month(), t.Day(), t.Hour()+1, 0, 0, 0, loc)
+		if t.Hour() == 0 {
+			goto wrap
+		}
+	}
+
+	for !s.minute[t.Minute()] {
+		t = t.Add(time.Minute)
+		if t.Minute() == 0 {
+			goto wrap
+		}
+	}
+
+	return t
+}
+
+func (s *Schedule) dayMatches(t time.Time) bool {
+	domMatch := s.dom[t.Day()]
+	dowMatch := s.dow[int(t.Weekday())]
+	if s.domStar || s.dowStar {
+		return domMatch && dowMatch
+	}
+	return domMatch || dowMatch
+}
@@ -0,0 +1,80 @@
+package cron
+
+import (
+	"testing"
+	"time"
+)
+
+func TestNext(t *testing.T) {
+	// 2026-10-01 is a Thursday.
+	from := time.Date(2026, 10, 1, 0, 0, 0, 0, time.UTC)
+
+	tests := []struct {
+		name string
+		expr string
+		from time.Time
+		want time.Time
+	}{
+		{"day of month only", "0 9 13 * *", from, time.Date(2026, 10, 13, 9, 0, 0, 0, time.UTC)},
+		{"day of week only", "0 9 * * 5", from, time.Date(2026, 10, 2, 9, 0, 0, 0, time.UTC)},
+		{"both days restricted match either", "0 9 13 * 5", from, time.Date(2026, 10, 2, 9, 0, 0, 0, time.UTC)},
+		{"either after the first week", "0 9 1-7 * mon", time.Date(2026, 10, 8, 0, 0, 0, 0, time.UTC), time.Date(2026, 10, 12, 9, 0, 0, 0, time.UTC)},
+		{"stepped star day of month matches both", "0 9 */2 * 5", from, time.Date(2026, 10, 9, 9, 0, 0, 0, time.UTC)},
+		{"stepped star day of week matches both", "0 9 13 * */2", from, time.Date(2026, 10, 13, 9, 0, 0, 0, time.UTC)},
+		{"question mark day of week", "0 9 13 * ?", from, time.Date(2026, 10, 13, 9, 0, 0, 0, time.UTC)},
+		{"sunday as 7", "30 6 * * 7", from, time.Date(2026, 10, 4, 6, 30, 0, 0, time.UTC)},
+		{"weekly macro", "@weekly", from, time.Date(2026, 10, 4, 0, 0, 0, 0, time.UTC)},
+		{"strictly after", "0 0 * * *", from, time.Date(2026, 10, 2, 0, 0, 0, 0, time.UTC)},
+		{"leap day", "0 0 29 2 *", from, time.Date(2028, 2, 29, 0, 0, 0, 0, time.UTC)},
+		{"never", "0 0 30 2 *", from, time.Time{}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s, err := Parse(tt.expr)
+			if err != nil {
+				t.Fatalf("Parse(%q): %v", tt.expr, err)
+			}
+			if got := s.Next(tt.from); !got.Equal(tt.want) {
+				t.Errorf("Next(%q) = %v, want %v", tt.expr, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestNextInLocation(t *testing.T) {
+	loc, err := time.LoadLocation("Asia/Kolkata")
+	if err != nil {
+		t.Skip("time zone data not available:", err)
+	}
+	s, err := Parse("0 9 * * *")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	got := s.Next(time.Date(2026, 10, 1, 10, 0, 0, 0, loc))
+	want := time.Date(2026, 10, 2, 9, 0, 0, 0, loc)
+	if !got.Equal(want) {
+		t.Errorf("Next = %v, want %v", got, want)
+	}
+}
+
+func TestParseErrors(t *testing.T) {
+	for _, expr := range []string{
+		"",
+		"* * * *",
+		"* * * * * *",
+		"60 * * * *",
+		"* 24 * * *",
+		"* * 0 * *",
+		"* * * 13 *",
+		"* * * * 8",
+		"5-1 * * * *",
+		"*/0 * * * *",
+		"* * * foo *",
+	} {
+		if _, err := Parse(expr); err == nil {
+			t.Errorf("Parse(%q) succeeded, want an error", expr)
+		}
+	}
+}
@@ -20,6 +20,15 @@ func Connect() {
 	}
 
 	DB = connection
-	connection.AutoMigrate(&models.User{}, &models.Group{})
+	connection.AutoMigrate(&models.User{}, &models.Group{}, &models.Recipient{}, &models.Campaign{}, &models.Delivery{}, &models.Suppression{}, &models.SourceCache{}, &models.Attachment{}, &models.DKIMKey{}, &models.SenderIdentity{}, &models.PendingImport{})
+	if err := migrateGroupRecipients(connection); err != nil {
+		panic(err)
+	}
+	if err := markPlainMessages(connection); err != nil {
+		panic(err)
+	}
+	if err := sealCredentials(connection); err != nil {
+		panic(err)
+	}
 	log.Println("Database connection successful")
 }
@@ -0,0 +1,223 @@
+package database
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"strings"
+	"text/template"
+	"time"
+
+	"github.com/karan-singh-17/Quick-Mail/mailer"
+	"github.com/karan-singh-17/Quick-Mail/models"
+	"github.com/karan-singh-17/Quick-Mail/vault"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// migrateGroupRecipients moves the comma-separated groups.recipients column
+// (and the merge fields kept next to it in recipient_data) into rows of the
+// recipients table, then drops the old columns. It is safe to re-run after
+// an interruption since existing rows are left untouched.
+func migrateGroupRecipients(db *gorm.DB) error {
+	migrator := db.Migrator()
+	if !migrator.HasColumn(&models.Group{}, "recipients") {
+		return nil
+	}
+	hasData := migrator.HasColumn(&models.Group{}, "recipient_data")
+
+	columns := []string{"group_id", "recipients"}
+	if hasData {
+		columns = append(columns, "recipient_data")
+	}
+
+	var groups []map[string]interface{}
+	if err := db.Table("groups").Select(columns).Where("recipients <> ''").Find(&groups).Error; err != nil {
+		return err
+	}
+
+	for _, group := range groups {
+		groupID := toString(group["group_id"])
+
+		attributes := make(map[string]map[string]string)
+		if data := toString(group["recipient_data"]); data != "" {
+			json.Unmarshal([]byte(data), &attributes)
+		}
+
+		var recipients []models.Recipient
+		seen := make(map[string]bool)
+		for _, email := range strings.Split(toString(group["recipients"]), ",") {
+			email = strings.TrimSpace(email)
+			if email == "" || seen[email] {
+				continue
+			}
+			seen[email] = true
+			recipients = append(recipients, models.Recipient{
+				Group_ID:   groupID,
+				Email:      email,
+				Attributes: attributes[email],
+				Status:     models.RecipientActive,
+				AddedAt:    time.Now(),
+			})
+		}
+		if len(recipients) == 0 {
+			continue
+		}
+
+		if err := db.Clauses(clause.OnConflict{DoNothing: true}).CreateInBatches(recipients, 500).Error; err != nil {
+			return err
+		}
+		log.Printf("Migrated %d recipients of group %s", len(recipients), groupID)
+	}
+
+	if hasData {
+		if err := migrator.DropColumn(&models.Group{}, "recipient_data"); err != nil {
+			return err
+		}
+	}
+	return migrator.DropColumn(&models.Group{}, "recipients")
+}
+
+func toString(value interface{}) string {
+	switch v := value.(type) {
+	case string:
+		return v
+	case []byte:
+		return string(v)
+	default:
+		return ""
+	}
+}
+
+// markPlainMessages turns merge fields off for groups whose subject or
+// message isn't a valid merge template, e.g. content written before merge
+// fields existed that carries placeholders of another tool. Saving such a
+// group with merge fields on is refused, so only those groups are matched
+// and re-running it changes nothing. Groups created before the column
+// existed may hold NULL there, which is read as merge fields on and set to
+// false once they have been checked.
+func markPlainMessages(db *gorm.DB) error {
+	var groups []models.Group
+	if err := db.Select("group_id, subject, message").Where("(plain_message = ? OR plain_message IS NULL)", false).
+		Where("(subject LIKE ? OR message LIKE ?)", "%{{%", "%{{%").Find(&groups).Error; err != nil {
+		return err
+	}
+
+	for _, group := range groups {
+		if parsesAsTemplate(group.Subject) && parsesAsTemplate(group.Message) {
+			continue
+		}
+		if err := db.Model(&models.Group{}).Where("group_id = ?", group.Group_ID).Update("plain_message", true).Error; err != nil {
+			return err
+		}
+		log.Printf("Group %s is not a valid merge template, sending it as plain message", group.Group_ID)
+	}
+	return db.Model(&models.Group{}).Where("plain_message IS NULL").Update("plain_message", false).Error
+}
+
+// parsesAsTemplate mirrors the parsing of merge templates in the handlers,
+// whose only function beyond the builtins is default.
+func parsesAsTemplate(text string) bool {
+	funcs := template.FuncMap{"default": func(fallback, value string) string { return value }}
+	_, err := template.New("").Funcs(funcs).Parse(text)
+	return err == nil
+}
+
+// sealCredentials encrypts SMTP passwords and DKIM keys still stored in
+// plaintext and re-wraps the ones sealed with a retired master key under
+// the active one, so a rotated key can be removed from vaultMasterKeys after
+// a restart. Without a usable vault the stored secrets can neither be
+// sealed nor opened, and the identities and keys using them couldn't be
+// used, so the server refuses to start instead.
+func sealCredentials(db *gorm.DB) error {
+	var identities []models.SenderIdentity
+	if err := db.Select("sender_id, smtp_password").Where("smtp_password <> ''").Find(&identities).Error; err != nil {
+		return err
+	}
+	var keys []models.DKIMKey
+	if err := db.Select("id, private_key, record").Find(&keys).Error; err != nil {
+		return err
+	}
+	if len(identities) == 0 && len(keys) == 0 {
+		return nil
+	}
+
+	v, err := vault.Default()
+	if err != nil {
+		return fmt.Errorf("%d SMTP passwords and %d DKIM keys are stored but can't be encrypted or decrypted, set vaultMasterKeys: %v", len(identities), len(keys), err)
+	}
+
+	for _, identity := range identities {
+		sealed, changed, err := sealSecret(v, identity.SMTPPassword)
+		if err != nil {
+			if !vault.IsSealed(identity.SMTPPassword) {
+				return err
+			}
+			log.Printf("Error re-encrypting SMTP password of %s: %v", identity.Sender_ID, err)
+			continue
+		}
+		if !changed {
+			continue
+		}
+		if err := db.Model(&models.SenderIdentity{}).Where("sender_id = ?", identity.Sender_ID).Update("smtp_password", sealed).Error; err != nil {
+			return err
+		}
+	}
+
+	for _, key := range keys {
+		updates := map[string]interface{}{}
+		if key.Record == "" {
+			record, err := dkimRecord(v, key.PrivateKey)
+			if err != nil {
+				log.Printf("Error reading DKIM key %d: %v", key.ID, err)
+				continue
+			}
+			updates["record"] = record
+		}
+		sealed, changed, err := sealSecret(v, key.PrivateKey)
+		if err != nil {
+			if !vault.IsSealed(key.PrivateKey) {
+				return err
+			}
+			log.Printf("Error re-encrypting DKIM key %d: %v", key.ID, err)
+			continue
+		}
+		if changed {
+			updates["private_key"] = sealed
+		}
+		if len(updates) == 0 {
+			continue
+		}
+		if err := db.Model(&models.DKIMKey{}).Where("id = ?", key.ID).Updates(updates).Error; err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// sealSecret returns secret sealed under the active master key and whether
+// that differs from what is stored. A secret sealed with a key that is no
+// longer configured can't be re-wrapped and is reported by the caller.
+func sealSecret(v *vault.Vault, secret string) (string, bool, error) {
+	if vault.IsSealed(secret) {
+		return v.Rewrap(secret)
+	}
+	sealed, err := v.Seal(secret)
+	return sealed, err == nil, err
+}
+
+// dkimRecord derives the DNS value of a stored DKIM key, for keys saved
+// before the record was stored next to them.
+func dkimRecord(v *vault.Vault, privateKey string) (string, error) {
+	if vault.IsSealed(privateKey) {
+		var err error
+		if privateKey, err = v.Open(privateKey); err != nil {
+			return "", err
+		}
+	}
+	key, err := mailer.ParseDKIMKey([]byte(privateKey))
+	if err != nil {
+		return "", err
+	}
+	return mailer.DKIMRecord(key)
+}
@@ -0,0 +1,342 @@
+// Package fetcher downloads user supplied URLs (recipient CSVs, HTML
+// messages, attachments) without letting them reach the server's own
+// network. Every connection is checked against the address it actually
+// dials, after DNS resolution and on every redirect, so private, loopback
+// and link-local ranges are refused unless explicitly allowlisted. Other
+// connections to user supplied hosts, such as the SMTP servers of sender
+// identities, go through the same dialer.
+//
+// It is configured with fetchTimeout (seconds, default 15), fetchMaxSize
+// (bytes, default 10 MB) and fetchAllowlist, a comma separated list of host
+// names, IPs or CIDR ranges that may be fetched even though they are
+// internal.
+package fetcher
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net"
+	"net/http"
+	"net/netip"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+)
+
+var (
+	// ErrBlocked is returned when a URL points at a disallowed address.
+	ErrBlocked = errors.New("fetcher: destination not allowed")
+	// ErrTooLarge is returned once a body exceeds the size limit.
+	ErrTooLarge = errors.New("fetcher: response too large")
+	// ErrContentType is returned for responses of an unexpected type.
+	ErrContentType = errors.New("fetcher: unexpected content type")
+)
+
+// blockedRanges are never dialed unless allowlisted: everything that isn't
+// a public unicast address.
+var blockedRanges = mustPrefixes(
+	"0.0.0.0/8",       // "this" network
+	"10.0.0.0/8",      // private
+	"100.64.0.0/10",   // carrier-grade NAT
+	"127.0.0.0/8",     // loopback
+	"169.254.0.0/16",  // link-local, cloud metadata
+	"172.16.0.0/12",   // private
+	"192.0.0.0/24",    // IETF protocol assignments
+	"192.0.2.0/24",    // documentation
+	"192.168.0.0/16",  // private
+	"198.18.0.0/15",   // benchmarking
+	"198.51.100.0/24", // documentation
+	"203.0.113.0/24",  // documentation
+	"224.0.0.0/4",     // multicast
+	"240.0.0.0/4",     // reserved, broadcast
+	"::/128",          // unspecified
+	"::1/128",         // loopback
+	"::/96",           // IPv4-compatible, embeds an IPv4 address
+	"64:ff9b::/96",    // NAT64, may map onto internal IPv4
+	"64:ff9b:1::/48",  // local-use NAT64
+	"100::/64",        // discard
+	"2001::/32",       // Teredo, embeds an IPv4 address
+	"2001:db8::/32",   // documentation
+	"2002::/16",       // 6to4, embeds an IPv4 address
+	"fc00::/7",        // unique local
+	"fe80::/10",       // link-local
+	"fec0::/10",       // site-local
+	"ff00::/8",        // multicast
+)
+
+func mustPrefixes(cidrs ...string) []netip.Prefix {
+	prefixes := make([]netip.Prefix, len(cidrs))
+	for i, cidr := range cidrs {
+		prefixes[i] = netip.MustParsePrefix(cidr)
+	}
+	return prefixes
+}
+
+// Fetcher is an HTTP client for untrusted URLs.
+type Fetcher struct {
+	// MaxBytes caps every response body.
+	MaxBytes int64
+
+	hosts    map[string]bool
+	prefixes []netip.Prefix
+	client   *http.Client
+	dialer   *net.Dialer
+	trusted  *net.Dialer
+}
+
+// New returns a fetcher giving up on a request after timeout and reading at
+// most maxBytes of a body. allow lists host names, IPs or CIDR ranges that
+// may be fetched even though they are internal.
+func New(timeout time.Duration, maxBytes int64, allow []string) (*Fetcher, error) {
+	f := &Fetcher{MaxBytes: maxBytes, hosts: make(map[string]bool)}
+	for _, entry := range allow {
+		entry = strings.ToLower(strings.TrimSpace(entry))
+		if entry == "" {
+			continue
+		}
+		if prefix, err := netip.ParsePrefix(entry); err == nil {
+			f.prefixes = append(f.prefixes, prefix.Masked())
+		} else if addr, err := netip.ParseAddr(entry); err == nil {
+			f.prefixes = append(f.prefixes, netip.PrefixFrom(addr.Unmap(), addr.Unmap().BitLen()))
+		} else if strings.ContainsAny(entry, "/:") {
+			return nil, fmt.Errorf("fetcher: invalid allowlist entry %q", entry)
+		} else {
+			f.hosts[strings.TrimSuffix(entry, ".")] = true
+		}
+	}
+
+	f.dialer = &net.Dialer{Timeout: 5 * time.Second, Control: f.control}
+	f.trusted = &net.Dialer{Timeout: 5 * time.Second}
+	transport := &http.Transport{
+		// Proxies from the environment would hide the real destination
+		// from the address check.
+		Proxy:                 nil,
+		DialContext:           f.DialContext,
+		TLSHandshakeTimeout:   5 * time.Second,
+		ResponseHeaderTimeout: timeout,
+		IdleConnTimeout:       30 * time.Second,
+		MaxIdleConns:          10,
+	}
+	f.client = &http.Client{
+		Timeout:   timeout,
+		Transport: transport,
+		CheckRedirect: func(req *http.Request, via []*http.Request) error {
+			if len(via) >= 5 {
+				return errors.New("fetcher: too many redirects")
+			}
+			return checkURL(req.URL)
+		},
+	}
+	return f, nil
+}
+
+// FromEnv builds a fetcher from fetchTimeout, fetchMaxSize and
+// fetchAllowlist.
+func FromEnv() (*Fetcher, error) {
+	timeout := 15 * time.Second
+	if seconds, err := strconv.Atoi(strings.TrimSpace(os.Getenv("fetchTimeout"))); err == nil && seconds > 0 {
+		timeout = time.Duration(seconds) * time.Second
+	}
+	maxBytes := int64(10 << 20)
+	if size, err := strconv.ParseInt(strings.TrimSpace(os.Getenv("fetchMaxSize")), 10, 64); err == nil && size > 0 {
+		maxBytes = size
+	}
+	return New(timeout, maxBytes, strings.Split(os.Getenv("fetchAllowlist"), ","))
+}
+
+var (
+	defaultOnce    sync.Once
+	defaultFetcher *Fetcher
+)
+
+// Default returns the fetcher configured from the environment. A malformed
+// allowlist panics at first use instead of being half applied.
+func Default() *Fetcher {
+	defaultOnce.Do(func() {
+		f, err := FromEnv()
+		if err != nil {
+			panic(err)
+		}
+		defaultFetcher = f
+	})
+	return defaultFetcher
+}
+
+// DialContext dials with the default fetcher; see Fetcher.DialContext.
+func DialContext(ctx context.Context, network, addr string) (net.Conn, error) {
+	return Default().DialContext(ctx, network, addr)
+}
+
+// CheckHost checks host with the default fetcher; see Fetcher.CheckHost.
+func CheckHost(ctx context.Context, host string) error {
+	return Default().CheckHost(ctx, host)
+}
+
+// Get fetches url with the default fetcher; see Fetcher.Do.
+func Get(url string, contentTypes ...string) (*http.Response, error) {
+	return Default().Get(url, contentTypes...)
+}
+
+// Do sends req with the default fetcher; see Fetcher.Do.
+func Do(req *http.Request, contentTypes ...string) (*http.Response, error) {
+	return Default().Do(req, contentTypes...)
+}
+
+// Get fetches url; see Do.
+func (f *Fetcher) Get(url string, contentTypes ...string) (*http.Response, error) {
+	req, err := http.NewRequest(http.MethodGet, url, nil)
+	if err != nil {
+		return nil, err
+	}
+	return f.Do(req, contentTypes...)
+}
+
+// Do sends the request. When contentTypes are given, a 200 response must
+// have one of them (or a subtype, for entries like "text/"). The returned
+// body fails with ErrTooLarge once more than MaxBytes are read from it.
+func (f *Fetcher) Do(req *http.Request, contentTypes ...string) (*http.Response, error) {
+	if err := checkURL(req.URL); err != nil {
+		return nil, err
+	}
+
+	resp, err := f.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+
+	if f.MaxBytes > 0 && resp.ContentLength > f.MaxBytes {
+		resp.Body.Close()
+		return nil, fmt.Errorf("%w: %d bytes", ErrTooLarge, resp.ContentLength)
+	}
+	if resp.StatusCode == http.StatusOK && len(contentTypes) > 0 && !allowedType(resp.Header.Get("Content-Type"), contentTypes) {
+		resp.Body.Close()
+		return nil, fmt.Errorf("%w %q", ErrContentType, resp.Header.Get("Content-Type"))
+	}
+	if f.MaxBytes > 0 {
+		resp.Body = &limitedBody{ReadCloser: resp.Body, remaining: f.MaxBytes}
+	}
+	return resp, nil
+}
+
+// DialContext connects the way the fetcher's own requests do: allowlisted
+// hosts directly, anything else only when it resolves to an allowed
+// address. Clients of other protocols talking to user supplied hosts, such
+// as SMTP, dial through it as well.
+func (f *Fetcher) DialContext(ctx context.Context, network, addr string) (net.Conn, error) {
+	if host, _, err := net.SplitHostPort(addr); err == nil && f.allowedHost(host) {
+		return f.trusted.DialContext(ctx, network, addr)
+	}
+	return f.dialer.DialContext(ctx, network, addr)
+}
+
+// CheckHost resolves host and fails with ErrBlocked when any of its
+// addresses is one the fetcher won't connect to, so a host can be refused
+// when it is saved rather than when it is first used. Connections are
+// still checked on their own, as the host may resolve differently later.
+func (f *Fetcher) CheckHost(ctx context.Context, host string) error {
+	if f.allowedHost(host) {
+		return nil
+	}
+	addrs, err := net.DefaultResolver.LookupNetIP(ctx, "ip", host)
+	if err != nil {
+		return err
+	}
+	for _, addr := range addrs {
+		if !f.allowedAddr(addr) {
+			return fmt.Errorf("%w: %s is an internal address", ErrBlocked, addr.Unmap())
+		}
+	}
+	return nil
+}
+
+func (f *Fetcher) allowedHost(host string) bool {
+	return f.hosts[strings.ToLower(strings.TrimSuffix(host, "."))]
+}
+
+// checkURL refuses anything but plain http(s) URLs.
+func checkURL(u *url.URL) error {
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return fmt.Errorf("%w: only http and https URLs can be fetched", ErrBlocked)
+	}
+	if u.User != nil {
+		return fmt.Errorf("%w: URLs with credentials can't be fetched", ErrBlocked)
+	}
+	if u.Hostname() == "" {
+		return fmt.Errorf("%w: URL has no host", ErrBlocked)
+	}
+	return nil
+}
+
+// control runs right before every connection, on the resolved address, so
+// neither DNS answers nor redirects can point the fetcher inwards.
+func (f *Fetcher) control(network, address string, _ syscall.RawConn) error {
+	host, _, err := net.SplitHostPort(address)
+	if err != nil {
+		return fmt.Errorf("%w: %s", ErrBlocked, address)
+	}
+	addr, err := netip.ParseAddr(host)
+	if err != nil {
+		return fmt.Errorf("%w: %s", ErrBlocked, address)
+	}
+	if !f.allowedAddr(addr) {
+		return fmt.Errorf("%w: %s is an internal address", ErrBlocked, addr)
+	}
+	return nil
+}
+
+func (f *Fetcher) allowedAddr(addr netip.Addr) bool {
+	addr = addr.Unmap().WithZone("")
+	for _, prefix := range f.prefixes {
+		if prefix.Contains(addr) {
+			return true
+		}
+	}
+	for _, prefix := range blockedRanges {
+		if prefix.Contains(addr) {
+			return false
+		}
+	}
+	return true
+}
+
+func allowedType(header string, contentTypes []string) bool {
+	mediaType, _, err := mime.ParseMediaType(header)
+	if err != nil {
+		mediaType = "application/octet-stream"
+	}
+	for _, allowed := range contentTypes {
+		if mediaType == allowed || (strings.HasSuffix(allowed, "/") && strings.HasPrefix(mediaType, allowed)) {
+			return true
+		}
+	}
+	return false
+}
+
+type limitedBody struct {
+	io.ReadCloser
+	remaining int64
+}
+
+func (b *limitedBody) Read(p []byte) (int, error) {
+	if b.remaining <= 0 {
+		// Only an error if there is more to read.
+		var probe [1]byte
+		if n, _ := b.ReadCloser.Read(probe[:]); n > 0 {
+			return 0, ErrTooLarge
+		}
+		return 0, io.EOF
+	}
+	if int64(len(p)) > b.remaining {
+		p = p[:b.remaining]
+	}
+	n, err := b.ReadCloser.Read(p)
+	b.remaining -= int64(n)
+	return n, err
+}
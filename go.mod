@@ -3,6 +3,9 @@ module github.com/karan-singh-17/Quick-Mail
 go 1.22.5
 
 require (
+	github.com/swaggo/http-swagger v1.3.4
+	github.com/swaggo/swag v1.16.3
+	golang.org/x/net v0.28.0
 	gorm.io/driver/mysql v1.5.7
 	gorm.io/gorm v1.25.11
 )
@@ -17,9 +20,6 @@ require (
 	github.com/josharian/intern v1.0.0 // indirect
 	github.com/mailru/easyjson v0.7.7 // indirect
 	github.com/swaggo/files v1.0.1 // indirect
-	github.com/swaggo/http-swagger v1.3.4 // indirect
-	github.com/swaggo/swag v1.16.3 // indirect
-	golang.org/x/net v0.28.0 // indirect
 	golang.org/x/tools v0.24.0 // indirect
 	gopkg.in/yaml.v2 v2.4.0 // indirect
 	gopkg.in/yaml.v3 v3.0.1 // indirect
@@ -32,5 +32,5 @@ require (
 	github.com/jinzhu/now v1.1.5 // indirect
 	github.com/rs/cors v1.11.0
 	golang.org/x/crypto v0.26.0
-	golang.org/x/text v0.17.0 // indirect
+	golang.org/x/text v0.17.0
 )
@@ -0,0 +1,346 @@
+package handlers
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"net/url"
+	"path"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	"github.com/karan-singh-17/Quick-Mail/database"
+	"github.com/karan-singh-17/Quick-Mail/fetcher"
+	"github.com/karan-singh-17/Quick-Mail/mailer"
+	"github.com/karan-singh-17/Quick-Mail/models"
+	"gorm.io/gorm"
+)
+
+// maxAttachmentSize caps a single file and maxGroupAttachmentSize all the
+// files of one group, since every mail of the group carries them.
+var (
+	maxAttachmentSize      = int64(envInt("maxAttachmentSize", 10<<20))
+	maxGroupAttachmentSize = int64(envInt("maxGroupAttachmentSize", 25<<20))
+)
+
+// blockedExtensions are refused outright; most providers reject mail that
+// carries them anyway.
+var blockedExtensions = map[string]bool{
+	".exe": true, ".bat": true, ".cmd": true, ".com": true, ".scr": true, ".pif": true,
+	".msi": true, ".vbs": true, ".js": true, ".jar": true, ".ps1": true, ".dll": true,
+}
+
+var contentIDChars = regexp.MustCompile(`[^a-z0-9._-]+`)
+
+// AttachmentData describes an attachment either by URL or by its base64
+// encoded content. Inline attachments must be images and are referenced from
+// the message as <img src="cid:content_id">.
+type AttachmentData struct {
+	URL       string `json:"url,omitempty"`
+	Filename  string `json:"filename,omitempty"`
+	Content   string `json:"content,omitempty"`
+	Inline    bool   `json:"inline,omitempty"`
+	ContentID string `json:"content_id,omitempty"`
+}
+
+// load fetches or decodes the attachment.
+func (d AttachmentData) load() (models.Attachment, error) {
+	if strings.TrimSpace(d.URL) != "" {
+		return fetchAttachment(d.URL, d.Filename, d.Inline, d.ContentID)
+	}
+	if d.Content == "" {
+		return models.Attachment{}, fmt.Errorf("attachment needs a url or content")
+	}
+	data, err := base64.StdEncoding.DecodeString(d.Content)
+	if err != nil {
+		return models.Attachment{}, fmt.Errorf("attachment content must be base64 encoded")
+	}
+	return newAttachment(d.Filename, bytes.NewReader(data), d.Inline, d.ContentID)
+}
+
+// newAttachment reads an attachment, enforcing the size limit and the
+// allowed types. The content type is sniffed from the data rather than
+// trusted from the client; the extension only refines generic results.
+func newAttachment(filename string, r io.Reader, inline bool, contentID string) (models.Attachment, error) {
+	filename = strings.Map(func(r rune) rune {
+		if r == '\r' || r == '\n' || r == '"' || r == '\\' {
+			return -1
+		}
+		return r
+	}, filepath.Base(strings.TrimSpace(filename)))
+	if filename == "" || filename == "." || filename == "/" {
+		filename = "attachment"
+	}
+	ext := strings.ToLower(filepath.Ext(filename))
+	if blockedExtensions[ext] {
+		return models.Attachment{}, fmt.Errorf("%s files can't be attached", ext)
+	}
+
+	data, err := io.ReadAll(io.LimitReader(r, maxAttachmentSize+1))
+	if err != nil {
+		return models.Attachment{}, err
+	}
+	if int64(len(data)) > maxAttachmentSize {
+		return models.Attachment{}, fmt.Errorf("%s is larger than %d bytes", filename, maxAttachmentSize)
+	}
+	if len(data) == 0 {
+		return models.Attachment{}, fmt.Errorf("%s is empty", filename)
+	}
+
+	contentType, _, _ := mime.ParseMediaType(http.DetectContentType(data))
+	switch contentType {
+	case "application/octet-stream", "text/plain", "application/zip":
+		if byExt, _, err := mime.ParseMediaType(mime.TypeByExtension(ext)); err == nil && byExt != "" {
+			contentType = byExt
+		}
+	}
+
+	attachment := models.Attachment{
+		Filename:    filename,
+		ContentType: contentType,
+		Size:        int64(len(data)),
+		Inline:      inline,
+		Data:        data,
+	}
+	if inline {
+		if !strings.HasPrefix(contentType, "image/") {
+			return models.Attachment{}, fmt.Errorf("only images can be inline, %s is %s", filename, contentType)
+		}
+		if contentID == "" {
+			contentID = filename
+		}
+		attachment.ContentID = strings.Trim(contentIDChars.ReplaceAllString(strings.ToLower(contentID), "-"), "-")
+		if attachment.ContentID == "" {
+			return models.Attachment{}, fmt.Errorf("invalid content_id %q", contentID)
+		}
+	}
+	return attachment, nil
+}
+
+// fetchAttachment downloads an attachment. The file name comes from the
+// response's Content-Disposition or the URL path when not given.
+func fetchAttachment(link, filename string, inline bool, contentID string) (models.Attachment, error) {
+	resp, err := fetcher.Get(link)
+	if err != nil {
+		return models.Attachment{}, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return models.Attachment{}, fmt.Errorf("error fetching attachment, status code: %d", resp.StatusCode)
+	}
+	if resp.ContentLength > maxAttachmentSize {
+		return models.Attachment{}, fmt.Errorf("attachment is larger than %d bytes", maxAttachmentSize)
+	}
+
+	if strings.TrimSpace(filename) == "" {
+		if _, params, err := mime.ParseMediaType(resp.Header.Get("Content-Disposition")); err == nil {
+			filename = params["filename"]
+		}
+	}
+	if strings.TrimSpace(filename) == "" {
+		if u, err := url.Parse(link); err == nil {
+			filename = path.Base(u.Path)
+		}
+	}
+
+	attachment, err := newAttachment(filename, resp.Body, inline, contentID)
+	attachment.SourceURL = link
+	return attachment, err
+}
+
+// saveAttachments stores attachments for a group after checking them
+// against the ones it already has.
+func saveAttachments(tx *gorm.DB, groupID string, attachments []models.Attachment) error {
+	if len(attachments) == 0 {
+		return nil
+	}
+
+	var existing []models.Attachment
+	if err := tx.Select("id, size, content_id").Where("group_id = ?", groupID).Find(&existing).Error; err != nil {
+		return err
+	}
+	if err := checkAttachments(existing, attachments); err != nil {
+		return err
+	}
+
+	for i := range attachments {
+		attachments[i].Group_ID = groupID
+	}
+	return tx.Create(&attachments).Error
+}
+
+// checkAttachments keeps a group under its total size limit and its inline
+// content IDs unique.
+func checkAttachments(existing, added []models.Attachment) error {
+	var total int64
+	contentIDs := make(map[string]bool)
+	for _, attachment := range existing {
+		total += attachment.Size
+		contentIDs[attachment.ContentID] = true
+	}
+	for _, attachment := range added {
+		total += attachment.Size
+		if attachment.ContentID != "" {
+			if contentIDs[attachment.ContentID] {
+				return fmt.Errorf("content_id %q is already used in this group", attachment.ContentID)
+			}
+			contentIDs[attachment.ContentID] = true
+		}
+	}
+	if total > maxGroupAttachmentSize {
+		return fmt.Errorf("attachments of a group can't exceed %d bytes in total", maxGroupAttachmentSize)
+	}
+	return nil
+}
+
+// groupAttachments loads the files to send with every mail of a group.
+func groupAttachments(groupID string) ([]mailer.Attachment, error) {
+	var rows []models.Attachment
+	if err := database.DB.Where("group_id = ?", groupID).Order("id").Find(&rows).Error; err != nil {
+		return nil, err
+	}
+
+	attachments := make([]mailer.Attachment, len(rows))
+	for i, row := range rows {
+		attachments[i] = mailer.Attachment{
+			Filename:    row.Filename,
+			ContentType: row.ContentType,
+			ContentID:   row.ContentID,
+			Inline:      row.Inline,
+			Data:        row.Data,
+		}
+	}
+	return attachments, nil
+}
+
+// List Attachments
+// @Summary list the attachments of a group
+// @Description returns the files sent with every mail of the group. Make sure you are logged in and are the owner of the group.
+// @Tags Attachments
+// @Produce json
+// @Param id path string true "Group ID"
+// @Success 200 {object} map[string]interface{} "attachments"
+// @Failure 401 {object} string "Unauthorized"
+// @Failure 404 {object} string "Group Not Found"
+// @Router /api/group/{id}/attachments [get]
+// @security jwt_token
+func ListAttachments(w http.ResponseWriter, r *http.Request) {
+	grp, _, ok := ownedGroup(w, r)
+	if !ok {
+		return
+	}
+
+	var attachments []models.Attachment
+	if err := database.DB.Omit("data").Where("group_id = ?", grp.Group_ID).Order("id").Find(&attachments).Error; err != nil {
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+
+	response := map[string]interface{}{
+		"status":      http.StatusOK,
+		"attachments": attachments,
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(response)
+}
+
+// Add Attachment
+// @Summary attach a file to a group
+// @Description attaches a file to every mail of the group. Upload it as multipart/form-data (field "file", optional "inline" and "content_id") or send JSON with a url or base64 content. Inline attachments must be images and are shown where the message uses <img src="cid:content_id">. Make sure you are logged in and are the owner of the group.
+// @Tags Attachments
+// @Accept json,mpfd
+// @Produce json
+// @Param id path string true "Group ID"
+// @Param attachment body AttachmentData false "Attachment by url or base64 content"
+// @Param file formData file false "File to upload"
+// @Success 201 {object} map[string]interface{} "Attachment added"
+// @Failure 400 {object} string "Invalid attachment"
+// @Failure 401 {object} string "Unauthorized"
+// @Failure 404 {object} string "Group Not Found"
+// @Router /api/group/{id}/attachments [post]
+// @security jwt_token
+func AddAttachment(w http.ResponseWriter, r *http.Request) {
+	grp, _, ok := ownedGroup(w, r)
+	if !ok {
+		return
+	}
+
+	var attachment models.Attachment
+	var err error
+	if strings.HasPrefix(r.Header.Get("Content-Type"), "multipart/form-data") {
+		r.Body = http.MaxBytesReader(w, r.Body, maxAttachmentSize+1<<20)
+		file, header, ferr := r.FormFile("file")
+		if ferr != nil {
+			http.Error(w, "Invalid Input", http.StatusBadRequest)
+			return
+		}
+		defer file.Close()
+		attachment, err = newAttachment(header.Filename, file, r.FormValue("inline") == "true", r.FormValue("content_id"))
+	} else {
+		var data AttachmentData
+		if err := json.NewDecoder(r.Body).Decode(&data); err != nil {
+			http.Error(w, "Invalid Input", http.StatusBadRequest)
+			return
+		}
+		attachment, err = data.load()
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	attachments := []models.Attachment{attachment}
+	if err := saveAttachments(database.DB, grp.Group_ID, attachments); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	response := map[string]interface{}{
+		"status":     http.StatusCreated,
+		"message":    "Attachment added",
+		"attachment": attachments[0],
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(response)
+}
+
+// Delete Attachment
+// @Summary remove an attachment from a group
+// @Description removes a file from the group so it is no longer sent. Make sure you are logged in and are the owner of the group.
+// @Tags Attachments
+// @Produce json
+// @Param id path string true "Group ID"
+// @Param attachmentID path int true "Attachment ID"
+// @Success 200 {object} map[string]string "message"
+// @Failure 401 {object} string "Unauthorized"
+// @Failure 404 {object} string "Attachment not found"
+// @Router /api/group/{id}/attachments/{attachmentID} [delete]
+// @security jwt_token
+func DeleteAttachment(w http.ResponseWriter, r *http.Request) {
+	grp, _, ok := ownedGroup(w, r)
+	if !ok {
+		return
+	}
+
+	res := database.DB.Where("id = ? AND group_id = ?", r.PathValue("attachmentID"), grp.Group_ID).Delete(&models.Attachment{})
+	if res.Error != nil {
+		http.Error(w, "Error removing attachment", http.StatusInternalServerError)
+		return
+	}
+	if res.RowsAffected == 0 {
+		http.Error(w, "Attachment not found", http.StatusNotFound)
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(map[string]string{"message": "Attachment removed"})
+}
@@ -0,0 +1,234 @@
+package handlers
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/karan-singh-17/Quick-Mail/database"
+	"github.com/karan-singh-17/Quick-Mail/models"
+)
+
+// Campaign Status
+// @Summary report the progress of a campaign
+// @Description returns how many recipients of a campaign were sent, failed, skipped (suppressed) or are still pending, along with every failed address and the SMTP response it got. Make sure you are logged in and are the owner of the group.
+// @Tags Campaigns
+// @Produce json
+// @Param id path string true "Group ID"
+// @Param campaignID path string true "Campaign ID"
+// @Success 200 {object} map[string]interface{} "Campaign report"
+// @Failure 401 {object} string "Unauthorized"
+// @Failure 404 {object} string "Campaign not found"
+// @Failure 500 {object} string "Internal Server Error"
+// @Router /api/group/{id}/campaigns/{campaignID} [get]
+// @security jwt_token
+func GetCampaignStatus(w http.ResponseWriter, r *http.Request) {
+	curr_user, err := GetUser(w, r)
+	if err != nil {
+		return
+	}
+
+	var campaign models.Campaign
+	if err := database.DB.Where("campaign_id = ? AND group_id = ?", r.PathValue("campaignID"), r.PathValue("id")).First(&campaign).Error; err != nil {
+		http.Error(w, "Campaign not found", http.StatusNotFound)
+		return
+	}
+
+	if campaign.Owner_ID != curr_user.Id {
+		http.Error(w, "You are not the owner of this group. Access Denied", http.StatusUnauthorized)
+		return
+	}
+
+	var counts []struct {
+		Status string
+		Count  int64
+	}
+	if err := database.DB.Model(&models.Delivery{}).Select("status, count(*) as count").
+		Where("campaign_id = ?", campaign.Campaign_ID).Group("status").Scan(&counts).Error; err != nil {
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+
+	totals := map[string]int64{models.DeliverySent: 0, models.DeliveryFailed: 0, models.DeliveryPending: 0, models.DeliverySkipped: 0}
+	for _, c := range counts {
+		totals[c.Status] = c.Count
+	}
+
+	var failures []models.Delivery
+	if err := database.DB.Where("campaign_id = ? AND status = ?", campaign.Campaign_ID, models.DeliveryFailed).
+		Order("recipient").Find(&failures).Error; err != nil {
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+
+	response := map[string]interface{}{
+		"status":   http.StatusOK,
+		"campaign": campaign,
+		"sent":     totals[models.DeliverySent],
+		"failed":   totals[models.DeliveryFailed],
+		"pending":  totals[models.DeliveryPending] + totals[models.DeliverySending],
+		"skipped":  totals[models.DeliverySkipped],
+		"failures": failures,
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(response)
+}
+
+var sendAtLayouts = []string{"2006-01-02T15:04", "2006-01-02T15:04:05", "2006-01-02 15:04", "2006-01-02 15:04:05"}
+
+// parseSendAt reads a local date and time in the given IANA time zone. The
+// zone is required so a schedule never silently depends on the server's.
+func parseSendAt(sendAt, timeZone string) (time.Time, error) {
+	if strings.TrimSpace(timeZone) == "" {
+		return time.Time{}, fmt.Errorf("time_zone is required with send_at")
+	}
+	loc, err := time.LoadLocation(timeZone)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("unknown time_zone %q", timeZone)
+	}
+
+	for _, layout := range sendAtLayouts {
+		at, err := time.ParseInLocation(layout, strings.TrimSpace(sendAt), loc)
+		if err != nil {
+			continue
+		}
+		if !at.After(time.Now()) {
+			return time.Time{}, fmt.Errorf("send_at must be in the future")
+		}
+		return at.UTC(), nil
+	}
+	return time.Time{}, fmt.Errorf("send_at must look like 2006-01-02T15:04")
+}
+
+// List Campaigns
+// @Summary list the campaigns of a group
+// @Description returns the campaigns (executions) of a group, newest first. Use status=scheduled to only see the scheduled ones. Make sure you are logged in and are the owner of the group.
+// @Tags Campaigns
+// @Produce json
+// @Param id path string true "Group ID"
+// @Param status query string false "Only campaigns with this status (scheduled, queued, running, completed, failed, cancelled)"
+// @Success 200 {object} map[string]interface{} "campaigns"
+// @Failure 401 {object} string "Unauthorized"
+// @Failure 404 {object} string "Group Not Found"
+// @Router /api/group/{id}/campaigns [get]
+// @security jwt_token
+func ListCampaigns(w http.ResponseWriter, r *http.Request) {
+	grp, _, ok := ownedGroup(w, r)
+	if !ok {
+		return
+	}
+
+	query := database.DB.Where("group_id = ?", grp.Group_ID)
+	if status := r.URL.Query().Get("status"); status != "" {
+		query = query.Where("status = ?", status)
+	}
+
+	var campaigns []models.Campaign
+	if err := query.Order("created_at desc").Find(&campaigns).Error; err != nil {
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+
+	response := map[string]interface{}{
+		"status":    http.StatusOK,
+		"campaigns": campaigns,
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(response)
+}
+
+// Reschedule Campaign
+// @Summary move a scheduled campaign to another time
+// @Description changes when a scheduled campaign is sent. Only campaigns that have not started yet can be rescheduled. Make sure you are logged in and are the owner of the group.
+// @Tags Campaigns
+// @Accept json
+// @Produce json
+// @Param id path string true "Group ID"
+// @Param campaignID path string true "Campaign ID"
+// @Param schedule body map[string]string true "send_at and time_zone" example({"send_at": "2026-10-20T09:00", "time_zone": "Asia/Kolkata"})
+// @Success 200 {object} map[string]interface{} "Campaign rescheduled"
+// @Failure 400 {object} string "Invalid Input"
+// @Failure 401 {object} string "Unauthorized"
+// @Failure 404 {object} string "Campaign not found"
+// @Failure 409 {object} string "Campaign is no longer scheduled"
+// @Router /api/group/{id}/campaigns/{campaignID} [put]
+// @security jwt_token
+func RescheduleCampaign(w http.ResponseWriter, r *http.Request) {
+	grp, _, ok := ownedGroup(w, r)
+	if !ok {
+		return
+	}
+
+	var data map[string]string
+	if err := json.NewDecoder(r.Body).Decode(&data); err != nil {
+		http.Error(w, "Invalid Input", http.StatusBadRequest)
+		return
+	}
+
+	sendAt, err := parseSendAt(data["send_at"], data["time_zone"])
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	res := database.DB.Model(&models.Campaign{}).
+		Where("campaign_id = ? AND group_id = ? AND status = ?", r.PathValue("campaignID"), grp.Group_ID, models.CampaignScheduled).
+		Updates(map[string]interface{}{"scheduled_at": sendAt, "time_zone": data["time_zone"]})
+	if res.Error != nil {
+		http.Error(w, "Error rescheduling campaign", http.StatusInternalServerError)
+		return
+	}
+	if res.RowsAffected == 0 {
+		http.Error(w, "Campaign is no longer scheduled", http.StatusConflict)
+		return
+	}
+
+	response := map[string]interface{}{
+		"status":       http.StatusOK,
+		"message":      "Campaign rescheduled",
+		"scheduled_at": sendAt,
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(response)
+}
+
+// Cancel Campaign
+// @Summary cancel a campaign that has not started
+// @Description cancels a scheduled or queued campaign. Campaigns that are already running cannot be cancelled. Make sure you are logged in and are the owner of the group.
+// @Tags Campaigns
+// @Produce json
+// @Param id path string true "Group ID"
+// @Param campaignID path string true "Campaign ID"
+// @Success 200 {object} map[string]string "message"
+// @Failure 401 {object} string "Unauthorized"
+// @Failure 404 {object} string "Campaign not found"
+// @Failure 409 {object} string "Campaign has already started"
+// @Router /api/group/{id}/campaigns/{campaignID} [delete]
+// @security jwt_token
+func CancelCampaign(w http.ResponseWriter, r *http.Request) {
+	grp, _, ok := ownedGroup(w, r)
+	if !ok {
+		return
+	}
+
+	res := database.DB.Model(&models.Campaign{}).
+		Where("campaign_id = ? AND group_id = ? AND status IN ?", r.PathValue("campaignID"), grp.Group_ID, []string{models.CampaignScheduled, models.CampaignQueued}).
+		Updates(map[string]interface{}{"status": models.CampaignCancelled, "finished_at": time.Now()})
+	if res.Error != nil {
+		http.Error(w, "Error cancelling campaign", http.StatusInternalServerError)
+		return
+	}
+	if res.RowsAffected == 0 {
+		http.Error(w, "Campaign has already started", http.StatusConflict)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(map[string]string{"message": "Campaign cancelled"})
+}
@@ -0,0 +1,172 @@
+package handlers
+
+import (
+	"log"
+	"time"
+
+	"github.com/karan-singh-17/Quick-Mail/database"
+	"github.com/karan-singh-17/Quick-Mail/models"
+)
+
+const (
+	campaignPollInterval = 5 * time.Second
+	campaignLease        = time.Minute
+)
+
+// campaignWake lets SendMailToGroup nudge an idle worker instead of waiting
+// for the next poll.
+var campaignWake = make(chan struct{}, 1)
+
+// StartCampaignWorkers launches the pool draining the campaign queue. The
+// pool size comes from the campaignWorkers variable (default 2).
+func StartCampaignWorkers() {
+	workers := envInt("campaignWorkers", 2)
+	for i := 0; i < workers; i++ {
+		go campaignWorker()
+	}
+	log.Printf("Started %d campaign workers", workers)
+}
+
+// enqueueCampaign creates a campaign for the group. With a non-nil sendAt
+// the campaign stays scheduled until that time, otherwise it is queued for
+// the next free worker.
+func enqueueCampaign(group models.Group, sendAt *time.Time, timeZone string) (models.Campaign, error) {
+	tokenid, err := generateToken()
+	if err != nil {
+		return models.Campaign{}, err
+	}
+
+	campaign := models.Campaign{
+		Campaign_ID: "c-" + tokenid,
+		Group_ID:    group.Group_ID,
+		Owner_ID:    group.Owner_ID,
+		Status:      models.CampaignQueued,
+		Trigger:     models.TriggerManual,
+		CreatedAt:   time.Now(),
+	}
+	if sendAt != nil {
+		campaign.Status = models.CampaignScheduled
+		campaign.ScheduledAt = sendAt
+		campaign.TimeZone = timeZone
+	}
+	if err := database.DB.Create(&campaign).Error; err != nil {
+		return models.Campaign{}, err
+	}
+
+	select {
+	case campaignWake <- struct{}{}:
+	default:
+	}
+	return campaign, nil
+}
+
+func campaignWorker() {
+	ticker := time.NewTicker(campaignPollInterval)
+	defer ticker.Stop()
+
+	for {
+		for {
+			campaign, ok := claimCampaign()
+			if !ok {
+				break
+			}
+			runCampaign(campaign)
+		}
+
+		select {
+		case <-campaignWake:
+		case <-ticker.C:
+		}
+	}
+}
+
+// claimCampaign takes the oldest queued campaign, a scheduled one that is
+// due, or a running one whose worker stopped renewing its lease. Because
+// schedules live in the database they survive restarts. The conditional
+// update makes sure only one worker wins a given campaign; a resumed
+// campaign only sends to the recipients whose Delivery is still pending.
+func claimCampaign() (models.Campaign, bool) {
+	for {
+		now := time.Now()
+
+		var campaign models.Campaign
+		err := database.DB.
+			Where("status = ? OR (status = ? AND scheduled_at <= ?) OR (status = ? AND lease_until < ?)",
+				models.CampaignQueued, models.CampaignScheduled, now, models.CampaignRunning, now).
+			Order("created_at").
+			First(&campaign).Error
+		if err != nil {
+			return models.Campaign{}, false
+		}
+
+		lease := now.Add(campaignLease)
+		updates := map[string]interface{}{"status": models.CampaignRunning, "lease_until": lease}
+		if campaign.StartedAt == nil {
+			updates["started_at"] = now
+		}
+
+		res := database.DB.Model(&models.Campaign{}).
+			Where("campaign_id = ? AND status = ? AND (lease_until IS NULL OR lease_until < ?) AND (scheduled_at IS NULL OR scheduled_at <= ?)",
+				campaign.Campaign_ID, campaign.Status, now, now).
+			Updates(updates)
+		if res.Error != nil {
+			log.Println("Error claiming campaign:", res.Error)
+			return models.Campaign{}, false
+		}
+		if res.RowsAffected == 1 {
+			if campaign.Status == models.CampaignRunning {
+				log.Println("Resuming interrupted campaign:", campaign.Campaign_ID)
+			}
+			campaign.Status = models.CampaignRunning
+			campaign.LeaseUntil = &lease
+			return campaign, true
+		}
+	}
+}
+
+func runCampaign(campaign models.Campaign) {
+	done := make(chan struct{})
+	go renewCampaignLease(campaign.Campaign_ID, done)
+	defer close(done)
+
+	var sendErr error
+	var group models.Group
+	if err := database.DB.Where("group_id = ?", campaign.Group_ID).First(&group).Error; err != nil {
+		sendErr = err
+	} else {
+		if campaign.Trigger == models.TriggerCron || group.LiveSources {
+			refreshGroupSources(&group)
+		}
+		sendErr = sendmailtogrp(campaign, group)
+	}
+
+	status := models.CampaignCompleted
+	errText := ""
+	if sendErr != nil {
+		status = models.CampaignFailed
+		errText = sendErr.Error()
+		log.Printf("Campaign %s failed: %v", campaign.Campaign_ID, sendErr)
+	}
+
+	if err := database.DB.Model(&models.Campaign{}).Where("campaign_id = ?", campaign.Campaign_ID).
+		Updates(map[string]interface{}{"status": status, "error": errText, "finished_at": time.Now(), "lease_until": nil}).Error; err != nil {
+		log.Println("Error updating campaign:", err)
+	}
+}
+
+func renewCampaignLease(campaignID string, done <-chan struct{}) {
+	ticker := time.NewTicker(campaignLease / 3)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-done:
+			return
+		case <-ticker.C:
+			if err := database.DB.Model(&models.Campaign{}).Where("campaign_id = ?", campaignID).
+				Update("lease_until", time.Now().Add(campaignLease)).Error; err != nil {
+				log.Println("Error renewing campaign lease:", err)
+			}
+		}
+	}
+}
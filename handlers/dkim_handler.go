@@ -0,0 +1,331 @@
+package handlers
+
+import (
+	"crypto"
+	"encoding/json"
+	"log"
+	"net/http"
+	"os"
+	"regexp"
+	"strings"
+
+	"github.com/karan-singh-17/Quick-Mail/database"
+	"github.com/karan-singh-17/Quick-Mail/mailer"
+	"github.com/karan-singh-17/Quick-Mail/models"
+	"github.com/karan-singh-17/Quick-Mail/vault"
+)
+
+var (
+	dkimDomainPattern   = regexp.MustCompile(`^([a-z0-9]([a-z0-9-]{0,61}[a-z0-9])?\.)+[a-z]{2,63}$`)
+	dkimSelectorPattern = regexp.MustCompile(`^[a-z0-9]([a-z0-9-]{0,61}[a-z0-9])?(\.[a-z0-9]([a-z0-9-]{0,61}[a-z0-9])?)*$`)
+)
+
+// systemDKIM signs mail sent from the server's own address (verification
+// and login mails, and groups of users without a key of their own). It is
+// configured with dkimPrivateKey, either the PEM itself or a path to it.
+var systemDKIM = loadSystemDKIM()
+
+func loadSystemDKIM() *mailer.DKIMSigner {
+	pemData := os.Getenv("dkimPrivateKey")
+	if strings.TrimSpace(pemData) == "" {
+		return nil
+	}
+	if !strings.Contains(pemData, "-----BEGIN") {
+		data, err := os.ReadFile(pemData)
+		if err != nil {
+			log.Println("Error reading dkimPrivateKey:", err)
+			return nil
+		}
+		pemData = string(data)
+	}
+
+	key, err := mailer.ParseDKIMKey([]byte(pemData))
+	if err != nil {
+		log.Println("Error loading dkimPrivateKey:", err)
+		return nil
+	}
+	return &mailer.DKIMSigner{
+		Domain:   envOr("dkimDomain", emailDomain(from)),
+		Selector: envOr("dkimSelector", "quickmail"),
+		Key:      key,
+	}
+}
+
+// emailDomain returns the lowercased domain of an address.
+func emailDomain(email string) string {
+	at := strings.LastIndex(email, "@")
+	if at < 0 {
+		return ""
+	}
+	return strings.ToLower(strings.TrimSpace(email[at+1:]))
+}
+
+// dkimSigner picks the key for mail sent by owner from the address: the
+// owner's key for that domain, else the system key when its domain matches.
+// A user's key only signs for domains of the user's verified senders, and
+// never for the system key's domain. It returns nil when the mail should go
+// out unsigned.
+func dkimSigner(ownerID, fromAddress string) *mailer.DKIMSigner {
+	domain := emailDomain(fromAddress)
+
+	if ownerID != "" && !isSystemDKIMDomain(domain) {
+		var stored models.DKIMKey
+		err := database.DB.Where("owner_id = ? AND domain = ?", ownerID, domain).Limit(1).Find(&stored).Error
+		if err != nil {
+			log.Println("Error loading DKIM key:", err)
+		} else if stored.ID != 0 && ownsSenderDomain(ownerID, stored.Domain) {
+			pemData, err := vault.Open(stored.PrivateKey)
+			if err == nil {
+				var key crypto.Signer
+				if key, err = mailer.ParseDKIMKey([]byte(pemData)); err == nil {
+					return &mailer.DKIMSigner{Domain: stored.Domain, Selector: stored.Selector, Key: key}
+				}
+			}
+			log.Printf("Error loading DKIM key %d: %v", stored.ID, err)
+		}
+	}
+
+	if systemDKIM != nil && systemDKIM.Domain == domain {
+		return systemDKIM
+	}
+	return nil
+}
+
+func isSystemDKIMDomain(domain string) bool {
+	return systemDKIM != nil && systemDKIM.Domain == domain
+}
+
+// ownsSenderDomain reports whether the user has a verified sender at the
+// domain, which is what lets the user's DKIM key sign for it.
+func ownsSenderDomain(ownerID, domain string) bool {
+	var count int64
+	err := database.DB.Model(&models.SenderIdentity{}).
+		Where("owner_id = ? AND verified = ? AND email LIKE ?", ownerID, true, "%@"+domain).Count(&count).Error
+	if err != nil {
+		log.Println("Error checking sender domain:", err)
+		return false
+	}
+	return count > 0
+}
+
+// dkimDNS describes the TXT record to publish for a key. Resolvers limit a
+// single string to 255 characters, so the value is also given in chunks.
+func dkimDNS(stored models.DKIMKey) map[string]interface{} {
+	record := stored.Record
+	var chunks []string
+	for rest := record; rest != ""; {
+		n := min(len(rest), 255)
+		chunks = append(chunks, rest[:n])
+		rest = rest[n:]
+	}
+
+	return map[string]interface{}{
+		"name":   stored.Selector + "._domainkey." + stored.Domain,
+		"type":   "TXT",
+		"value":  record,
+		"chunks": chunks,
+	}
+}
+
+type DKIMKeyData struct {
+	Domain     string `json:"domain"`
+	Selector   string `json:"selector"`
+	Algorithm  string `json:"algorithm,omitempty"`
+	PrivateKey string `json:"private_key,omitempty"`
+}
+
+// List DKIM Keys
+// @Summary list your DKIM keys
+// @Description returns the DKIM keys of the current user, one per sender domain. Private keys are never returned.
+// @Tags DKIM
+// @Produce json
+// @Success 200 {object} map[string]interface{} "keys"
+// @Failure 401 {object} string "Unauthorized"
+// @Router /api/dkim/keys [get]
+// @security jwt_token
+func ListDKIMKeys(w http.ResponseWriter, r *http.Request) {
+	curr_user, err := GetUser(w, r)
+	if err != nil {
+		return
+	}
+
+	var keys []models.DKIMKey
+	if err := database.DB.Where("owner_id = ?", curr_user.Id).Order("domain").Find(&keys).Error; err != nil {
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+
+	response := map[string]interface{}{
+		"status": http.StatusOK,
+		"keys":   keys,
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(response)
+}
+
+// Add DKIM Key
+// @Summary add a DKIM key for a sender domain
+// @Description stores a DKIM key used to sign mail sent from the domain. Upload a PEM encoded RSA or Ed25519 private key, or leave private_key empty to have one generated (algorithm "rsa" or "ed25519", default rsa). The domain must belong to one of your verified senders. A key already stored for the domain is replaced. The response contains the DNS TXT record to publish.
+// @Tags DKIM
+// @Accept json
+// @Produce json
+// @Param key body DKIMKeyData true "Domain, selector and optional key"
+// @Success 201 {object} map[string]interface{} "Key and DNS record"
+// @Failure 400 {object} string "Invalid Input"
+// @Failure 401 {object} string "Unauthorized"
+// @Router /api/dkim/keys [post]
+// @security jwt_token
+func AddDKIMKey(w http.ResponseWriter, r *http.Request) {
+	curr_user, err := GetUser(w, r)
+	if err != nil {
+		return
+	}
+
+	var data DKIMKeyData
+	if err := json.NewDecoder(r.Body).Decode(&data); err != nil {
+		http.Error(w, "Invalid Input", http.StatusBadRequest)
+		return
+	}
+
+	domain := strings.ToLower(strings.TrimSpace(data.Domain))
+	selector := strings.ToLower(strings.TrimSpace(data.Selector))
+	if !dkimDomainPattern.MatchString(domain) {
+		http.Error(w, "Invalid domain", http.StatusBadRequest)
+		return
+	}
+	if !dkimSelectorPattern.MatchString(selector) {
+		http.Error(w, "Invalid selector", http.StatusBadRequest)
+		return
+	}
+
+	pemData := []byte(data.PrivateKey)
+	if strings.TrimSpace(data.PrivateKey) == "" {
+		algorithm := strings.ToLower(strings.TrimSpace(data.Algorithm))
+		if algorithm == "" {
+			algorithm = "rsa"
+		}
+		if pemData, err = mailer.GenerateDKIMKey(algorithm); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+	}
+
+	if isSystemDKIMDomain(domain) {
+		http.Error(w, domain+" is signed by the server's own key", http.StatusBadRequest)
+		return
+	}
+	if !ownsSenderDomain(curr_user.Id, domain) {
+		http.Error(w, "Add and verify a sender at "+domain+" first", http.StatusBadRequest)
+		return
+	}
+
+	key, err := mailer.ParseDKIMKey(pemData)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	record, err := mailer.DKIMRecord(key)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	sealedKey, err := vault.Seal(string(pemData))
+	if err != nil {
+		log.Println("Error sealing DKIM key:", err)
+		http.Error(w, "DKIM keys can't be stored on this server", http.StatusInternalServerError)
+		return
+	}
+
+	stored := models.DKIMKey{
+		Owner_ID:   curr_user.Id,
+		Domain:     domain,
+		Selector:   selector,
+		Algorithm:  strings.TrimSuffix((&mailer.DKIMSigner{Key: key}).Algorithm(), "-sha256"),
+		PrivateKey: sealedKey,
+		Record:     record,
+	}
+
+	if err := database.DB.Where("owner_id = ? AND domain = ?", curr_user.Id, domain).Delete(&models.DKIMKey{}).Error; err != nil {
+		http.Error(w, "Error saving DKIM key", http.StatusInternalServerError)
+		return
+	}
+	if err := database.DB.Create(&stored).Error; err != nil {
+		http.Error(w, "Error saving DKIM key", http.StatusInternalServerError)
+		return
+	}
+
+	response := map[string]interface{}{
+		"status":  http.StatusCreated,
+		"message": "DKIM key saved",
+		"key":     stored,
+		"dns":     dkimDNS(stored),
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(response)
+}
+
+// DKIM DNS Record
+// @Summary show the DNS record for a DKIM key
+// @Description returns the name and value of the TXT record to publish so receivers can verify mail signed with the key.
+// @Tags DKIM
+// @Produce json
+// @Param id path int true "Key ID"
+// @Success 200 {object} map[string]interface{} "DNS record"
+// @Failure 401 {object} string "Unauthorized"
+// @Failure 404 {object} string "Key not found"
+// @Router /api/dkim/keys/{id}/dns [get]
+// @security jwt_token
+func GetDKIMRecord(w http.ResponseWriter, r *http.Request) {
+	curr_user, err := GetUser(w, r)
+	if err != nil {
+		return
+	}
+
+	var stored models.DKIMKey
+	if err := database.DB.Where("id = ? AND owner_id = ?", r.PathValue("id"), curr_user.Id).First(&stored).Error; err != nil {
+		http.Error(w, "Key not found", http.StatusNotFound)
+		return
+	}
+
+	response := map[string]interface{}{
+		"status": http.StatusOK,
+		"dns":    dkimDNS(stored),
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(response)
+}
+
+// Delete DKIM Key
+// @Summary delete a DKIM key
+// @Description removes the key; mail from its domain is no longer signed with it.
+// @Tags DKIM
+// @Produce json
+// @Param id path int true "Key ID"
+// @Success 200 {object} map[string]string "message"
+// @Failure 401 {object} string "Unauthorized"
+// @Failure 404 {object} string "Key not found"
+// @Router /api/dkim/keys/{id} [delete]
+// @security jwt_token
+func DeleteDKIMKey(w http.ResponseWriter, r *http.Request) {
+	curr_user, err := GetUser(w, r)
+	if err != nil {
+		return
+	}
+
+	res := database.DB.Where("id = ? AND owner_id = ?", r.PathValue("id"), curr_user.Id).Delete(&models.DKIMKey{})
+	if res.Error != nil {
+		http.Error(w, "Error deleting DKIM key", http.StatusInternalServerError)
+		return
+	}
+	if res.RowsAffected == 0 {
+		http.Error(w, "Key not found", http.StatusNotFound)
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(map[string]string{"message": "DKIM key deleted"})
+}
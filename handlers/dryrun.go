@@ -0,0 +1,205 @@
+package handlers
+
+import (
+	"strings"
+	"time"
+
+	"github.com/karan-singh-17/Quick-Mail/database"
+	"github.com/karan-singh-17/Quick-Mail/models"
+	"gorm.io/gorm"
+)
+
+// dryRunSample caps how many addresses are listed per category in a dry-run
+// report; the counts always cover every recipient.
+const dryRunSample = 50
+
+// dryRunGroup reports what executing the group would do, applying the same
+// source refresh as runCampaign and the same checks as sendmailtogrp,
+// without sending or recording anything.
+func dryRunGroup(group models.Group) (map[string]interface{}, error) {
+	recipients, err := activeRecipients(group.Group_ID)
+	if err != nil {
+		return nil, err
+	}
+
+	sourceErrors := []string{}
+	if group.LiveSources {
+		if recipients, sourceErrors, err = dryRunSources(&group, recipients); err != nil {
+			return nil, err
+		}
+	}
+
+	emails := make([]string, len(recipients))
+	for i, recipient := range recipients {
+		emails[i] = recipient.Email
+	}
+	suppressed, err := suppressedEmails(group.Owner_ID, emails)
+	if err != nil {
+		return nil, err
+	}
+
+	var problems []string
+	merge, err := groupTemplate(group)
+	if err != nil {
+		problems = append(problems, err.Error())
+	}
+	if err := checkSender(group.Owner_ID, group.Sender_ID); err != nil {
+		problems = append(problems, err.Error())
+	}
+
+	var valid, wouldSend int
+	invalid := []string{}
+	duplicates := []string{}
+	suppressedSample := map[string]string{}
+	renderErrors := map[string]string{}
+	invalidCount, duplicateCount, suppressedCount, renderErrorCount := 0, 0, 0, 0
+
+	seen := make(map[string]bool)
+	for _, recipient := range recipients {
+		email := strings.TrimSpace(recipient.Email)
+		if email == "" {
+			continue
+		}
+		key := recipientKey(email, group.MergePlusAddresses)
+		if seen[key] {
+			duplicateCount++
+			if len(duplicates) < dryRunSample {
+				duplicates = append(duplicates, email)
+			}
+			continue
+		}
+		seen[key] = true
+
+		if reason, ok := suppressed[email]; ok {
+			suppressedCount++
+			if len(suppressedSample) < dryRunSample {
+				suppressedSample[email] = reason
+			}
+			continue
+		}
+		if !isValidEmail(email) {
+			invalidCount++
+			if len(invalid) < dryRunSample {
+				invalid = append(invalid, email)
+			}
+			continue
+		}
+		valid++
+
+		if merge != nil {
+			if _, _, err := merge.render(email, recipient.Attributes); err != nil {
+				renderErrorCount++
+				if len(renderErrors) < dryRunSample {
+					renderErrors[email] = err.Error()
+				}
+				continue
+			}
+		}
+		wouldSend++
+	}
+
+	if valid == 0 {
+		problems = append(problems, "no valid recipients found")
+	}
+	if len(problems) > 0 {
+		wouldSend = 0
+	}
+
+	report := map[string]interface{}{
+		"recipients":          len(recipients),
+		"valid":               valid,
+		"invalid":             invalidCount,
+		"invalid_emails":      invalid,
+		"duplicates":          duplicateCount,
+		"duplicate_emails":    duplicates,
+		"suppressed":          suppressedCount,
+		"suppressed_emails":   suppressedSample,
+		"render_errors":       renderErrorCount,
+		"render_error_emails": renderErrors,
+		"would_send":          wouldSend,
+		"errors":              problems,
+		"source_errors":       sourceErrors,
+	}
+
+	// Only the rate limits bound the duration; with none set it depends
+	// on the SMTP server.
+	if rate := sendThrottle.Rate(); rate > 0 {
+		estimate := time.Duration(float64(wouldSend) / rate * float64(time.Second)).Round(time.Second)
+		report["rate_per_second"] = rate
+		report["estimated_duration"] = estimate.String()
+		report["estimated_seconds"] = int64(estimate / time.Second)
+	} else {
+		report["rate_per_second"] = "unlimited"
+	}
+
+	return report, nil
+}
+
+// dryRunSources applies the link refresh a real execution of a group with
+// live_sources does to the group and its active recipients, in memory only.
+// A link that can't be used is reported; the run then keeps the group's last
+// good list or message, as a real one does.
+func dryRunSources(group *models.Group, recipients []models.Recipient) ([]models.Recipient, []string, error) {
+	sourceErrors := []string{}
+	if group.CSVLink != "" {
+		fetched, _, changed, err := loadRecipientsSource(*group)
+		if err != nil {
+			sourceErrors = append(sourceErrors, "csv_link: "+err.Error()+", the last good list would be sent")
+		} else if changed {
+			if recipients, err = refreshedRecipients(*group, recipients, fetched); err != nil {
+				return nil, nil, err
+			}
+		}
+	}
+
+	if group.HTMLLink != "" {
+		message, _, changed, err := loadMessageSource(*group)
+		if err != nil {
+			sourceErrors = append(sourceErrors, "html_link: "+err.Error()+", the last good message would be sent")
+		} else if changed {
+			group.Message = message
+		}
+	}
+	return recipients, sourceErrors, nil
+}
+
+// refreshedRecipients returns the active members refreshRecipients would
+// leave: the ones not read from the link, then the fetched addresses that
+// don't match a remaining member, merged the way saveRecipients merges them.
+func refreshedRecipients(group models.Group, recipients []models.Recipient, fetched []importedRecipient) ([]models.Recipient, error) {
+	emails := make([]string, len(fetched))
+	for i, recipient := range fetched {
+		emails[i] = recipient.Email
+	}
+	others := database.DB.Where("(source IS NULL OR source <> ?)", models.RecipientSourceCSV).Session(&gorm.Session{})
+	seen, err := existingKeys(others, group, emails)
+	if err != nil {
+		return nil, err
+	}
+
+	var result []models.Recipient
+	for _, recipient := range recipients {
+		if recipient.Source != models.RecipientSourceCSV {
+			result = append(result, recipient)
+		}
+	}
+	for _, recipient := range fetched {
+		email := normalizeEmail(recipient.Email)
+		if email == "" {
+			continue
+		}
+		key := recipientKey(email, group.MergePlusAddresses)
+		if seen[key] {
+			continue
+		}
+		seen[key] = true
+		result = append(result, models.Recipient{
+			Group_ID:   group.Group_ID,
+			Email:      email,
+			Attributes: recipient.Attributes,
+			Status:     models.RecipientActive,
+			Source:     models.RecipientSourceCSV,
+		})
+	}
+	return result, nil
+}
@@ -2,76 +2,103 @@ package handlers
 
 import (
 	"encoding/json"
+	"errors"
 	"fmt"
+	"io"
+	"log"
+	"net"
 	"net/http"
-	"net/smtp"
+	"net/mail"
+	"net/textproto"
 	"os"
 	"strings"
 	"sync"
+	"time"
 
 	"github.com/karan-singh-17/Quick-Mail/database"
+	"github.com/karan-singh-17/Quick-Mail/fetcher"
+	"github.com/karan-singh-17/Quick-Mail/mailer"
 	"github.com/karan-singh-17/Quick-Mail/models"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
 )
 
 var from = os.Getenv("from")
-var password = os.Getenv("password")
-var smtpHost = os.Getenv("smtpHost")
-var smtpPort = os.Getenv("smtpPort")
+
+// transport delivers every outgoing message; see mailer.NewFromEnv for the
+// available configurations.
+var transport = mailer.NewFromEnv()
+
+// retryPolicy governs how group mails recover from transient SMTP failures.
+var retryPolicy = mailer.RetryPolicyFromEnv()
+
+// sendThrottle is shared by every running campaign so the configured rate
+// limits hold across the whole server.
+var sendThrottle = mailer.ThrottleFromEnv()
 
 func sendVerificationEmail(email, token string) error {
 
 	to := email
-	auth := smtp.PlainAuth("", from, password, smtpHost)
 
 	htmlContent, err := os.ReadFile("templates/verify_mail.html")
 	if err != nil {
 		return fmt.Errorf("error reading HTML template: %v", err)
 	}
 
-	subject := "Subject: Verify Your Account\n"
-
 	htmlText := strings.ReplaceAll(string(htmlContent), "{{TOKEN}}", token)
 
-	message := []byte(subject + "MIME-Version: 1.0\nContent-Type: text/html; charset=\"UTF-8\";\n\n" + htmlText)
-
-	addr := fmt.Sprintf("%s:%s", smtpHost, smtpPort)
+	message, err := (&mailer.Email{
+		From:    mail.Address{Address: from},
+		To:      []string{to},
+		Subject: "Verify Your Account",
+		HTML:    htmlText,
+		DKIM:    dkimSigner("", from),
+	}).Bytes()
+	if err != nil {
+		return err
+	}
 
-	return smtp.SendMail(addr, auth, from, []string{to}, message)
+	return transport.Send(from, []string{to}, message)
 }
 
 func sendLoginCode(email, code string) error {
 	to := email
 
-	auth := smtp.PlainAuth("", from, password, smtpHost)
-
 	htmlContent, err := os.ReadFile("templates/send_mail_temp.html")
 	if err != nil {
 		return fmt.Errorf("error reading HTML template: %v", err)
 	}
 
-	subject := "Subject: Your Login Code\n"
-
 	htmlText := strings.ReplaceAll(string(htmlContent), "{{MESSAGE}}", code)
+	htmlText = strings.ReplaceAll(htmlText, "{{UNSUBSCRIBE}}", "")
+
+	message, err := (&mailer.Email{
+		From:    mail.Address{Address: from},
+		To:      []string{to},
+		Subject: "Your Login Code",
+		HTML:    htmlText,
+		DKIM:    dkimSigner("", from),
+	}).Bytes()
+	if err != nil {
+		return err
+	}
 
-	message := []byte(subject + "MIME-Version: 1.0\nContent-Type: text/html; charset=\"UTF-8\";\n\n" + htmlText)
-
-	addr := fmt.Sprintf("%s:%s", smtpHost, smtpPort)
-
-	return smtp.SendMail(addr, auth, from, []string{to}, message)
+	return transport.Send(from, []string{to}, message)
 }
 
 // Execute Group
 // @Summary execute/run the group
-// @Description starts the process of sending emails to the recipients. Make sure you are logged in and are the owner of the group.
+// @Description queues the group for sending and returns the campaign (job) ID right away. The emails are sent in the background by the campaign workers. Pass "send_at" (local date and time, e.g. "2026-10-20T09:00") together with an IANA "time_zone" (e.g. "Asia/Kolkata") to schedule the campaign instead. With "dry_run": "true" nothing is queued or sent; the response reports how many addresses are valid, invalid, duplicated or suppressed, which ones fail to render, which links can't be fetched for groups with live_sources, and how long sending would take under the rate limits. Make sure you are logged in and are the owner of the group.
 // @Tags Groups
 // @Accept json
 // @Produce json
-// @Param group_id body map[string]string true "Group ID" example({"group_id": "example-group-id"})
-// @Success 200
+// @Param group_id body map[string]string true "Group ID, optional send_at, time_zone and dry_run" example({"group_id": "example-group-id"})
+// @Success 200 {object} map[string]interface{} "Dry-run report"
+// @Success 202 {object} map[string]interface{} "Campaign queued"
 // @Failure 400 {object} string "Invalid Input"
 // @Failure 401 {object} string "Unauthorized"
 // @Failure 404 {object} string "Group not found"
-// @Failure 500 {object} string "Error in sending mails"
+// @Failure 500 {object} string "Error queueing campaign"
 // @Router /api/group/execute-group [post]
 // @Example { "group_id": "example-group-id" }
 func SendMailToGroup(w http.ResponseWriter, r *http.Request) {
@@ -104,60 +131,317 @@ func SendMailToGroup(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	errdf := sendmailtogrp(curr_grp)
+	var sendAt *time.Time
+	if strings.TrimSpace(g_id["send_at"]) != "" {
+		at, err := parseSendAt(g_id["send_at"], g_id["time_zone"])
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		sendAt = &at
+	}
+
+	if g_id["dry_run"] == "true" {
+		report, err := dryRunGroup(curr_grp)
+		if err != nil {
+			http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+			return
+		}
+		report["status"] = http.StatusOK
+		report["dry_run"] = true
+		if sendAt != nil {
+			report["scheduled_at"] = sendAt
+		}
 
-	if errdf != nil {
-		http.Error(w, "Error in sending mails", http.StatusInternalServerError)
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(report)
 		return
 	}
 
-}
+	campaign, err := enqueueCampaign(curr_grp, sendAt, g_id["time_zone"])
+	if err != nil {
+		http.Error(w, "Error queueing campaign", http.StatusInternalServerError)
+		return
+	}
 
-func sendmailtogrp(group models.Group) error {
-	auth := smtp.PlainAuth("", from, password, smtpHost)
-	recipients := strings.Split(group.Recipients, ",")
+	response := map[string]interface{}{
+		"status":      http.StatusAccepted,
+		"message":     "Campaign queued",
+		"campaign_id": campaign.Campaign_ID,
+	}
+	if sendAt != nil {
+		response["message"] = "Campaign scheduled"
+		response["scheduled_at"] = sendAt
+	}
 
-	validRecipients := []string{}
-	for _, recipient := range recipients {
-		recipient = strings.TrimSpace(recipient)
-		if recipient != "" && isValidEmail(recipient) {
-			validRecipients = append(validRecipients, recipient)
-		}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusAccepted)
+	json.NewEncoder(w).Encode(response)
+}
+
+// sendmailtogrp delivers the group message for a campaign. Every recipient
+// gets a Delivery row; only the ones still pending are sent, so calling it
+// again for an interrupted campaign picks up where it stopped. Individual
+// failures are recorded on their Delivery rather than returned.
+func sendmailtogrp(campaign models.Campaign, group models.Group) error {
+	recipients, err := activeRecipients(group.Group_ID)
+	if err != nil {
+		return err
 	}
 
-	if len(validRecipients) == 0 {
-		return fmt.Errorf("no valid recipients found")
+	emails := make([]string, len(recipients))
+	attributes := make(map[string]map[string]string, len(recipients))
+	for i, recipient := range recipients {
+		emails[i] = recipient.Email
+		attributes[recipient.Email] = recipient.Attributes
 	}
 
-	subject := "Subject: " + group.Subject + "\n"
-	htmlContent, err := os.ReadFile("templates/send_mail_temp.html")
+	suppressed, err := suppressedEmails(group.Owner_ID, emails)
 	if err != nil {
-		return fmt.Errorf("error reading HTML template: %v", err)
+		return err
 	}
 
-	htmlText := strings.ReplaceAll(string(htmlContent), "{{MESSAGE}}", group.Message)
-	message := []byte(subject + "MIME-Version: 1.0\nContent-Type: text/html; charset=\"UTF-8\";\n\n" + htmlText)
-	addr := fmt.Sprintf("%s:%s", smtpHost, smtpPort)
+	if err := createDeliveries(campaign, emails, suppressed, group.MergePlusAddresses); err != nil {
+		return err
+	}
+	if err := failInterruptedDeliveries(campaign); err != nil {
+		return err
+	}
 
+	var pending []models.Delivery
+	if err := database.DB.Where("campaign_id = ? AND status = ?", campaign.Campaign_ID, models.DeliveryPending).Find(&pending).Error; err != nil {
+		return err
+	}
+	if len(pending) == 0 {
+		return nil
+	}
+
+	composer, err := newGroupComposer(group)
+	if err != nil {
+		return err
+	}
+
+	throttled := sendThrottle.Wrap(composer.sender.Transport)
+	queue := make(chan models.Delivery)
 	var wg sync.WaitGroup
-	errCh := make(chan error, len(validRecipients))
 
-	for _, recipient := range validRecipients {
+	workers := envInt("sendWorkers", 10)
+	if workers < 1 {
+		workers = 1
+	}
+	for i := 0; i < workers && i < len(pending); i++ {
 		wg.Add(1)
-		go func(recipient string) {
+		go func() {
 			defer wg.Done()
-			err := smtp.SendMail(addr, auth, from, []string{recipient}, message)
-			if err != nil {
-				errCh <- fmt.Errorf("error sending email to %s: %v", recipient, err)
+			for delivery := range queue {
+				if !claimDelivery(delivery) {
+					continue
+				}
+				email, err := composer.compose(delivery.Recipient, attributes[delivery.Recipient], delivery.Recipient)
+				if err != nil {
+					recordAttempt(delivery, 0, err)
+					continue
+				}
+				message, err := email.Bytes()
+				if err != nil {
+					recordAttempt(delivery, 0, fmt.Errorf("error building message: %v", err))
+					continue
+				}
+
+				attempts, err := retryPolicy.Send(throttled, composer.sender.From.Address, []string{delivery.Recipient}, message)
+				recordAttempt(delivery, attempts, err)
+				if mailer.IsHardBounce(err) {
+					// A bounce reported by a user's own server could be made
+					// up, so it only suppresses the address for that user.
+					scope := ""
+					if composer.sender.OwnServer {
+						scope = group.Owner_ID
+					}
+					if err := suppress(scope, delivery.Recipient, models.SuppressionBounce, group.Group_ID); err != nil {
+						log.Println("Error suppressing bounced address:", err)
+					}
+				}
 			}
-		}(recipient)
+		}()
 	}
 
+	for _, delivery := range pending {
+		queue <- delivery
+	}
+	close(queue)
+
 	wg.Wait()
-	close(errCh)
+	return nil
+}
 
-	if len(errCh) > 0 {
-		return <-errCh
+// groupComposer assembles the mails of a group: the merged message wrapped
+// in templates/send_mail_temp.html, its attachments, sender and signature.
+type groupComposer struct {
+	group       models.Group
+	merge       *mergeTemplate
+	layout      string
+	attachments []mailer.Attachment
+	sender      mailSender
+	signer      *mailer.DKIMSigner
+}
+
+func newGroupComposer(group models.Group) (*groupComposer, error) {
+	merge, err := groupTemplate(group)
+	if err != nil {
+		return nil, err
+	}
+
+	htmlContent, err := os.ReadFile("templates/send_mail_temp.html")
+	if err != nil {
+		return nil, fmt.Errorf("error reading HTML template: %v", err)
+	}
+
+	attachments, err := groupAttachments(group.Group_ID)
+	if err != nil {
+		return nil, err
+	}
+	sender, err := groupSender(group)
+	if err != nil {
+		return nil, err
+	}
+
+	return &groupComposer{
+		group:       group,
+		merge:       merge,
+		layout:      string(htmlContent),
+		attachments: attachments,
+		sender:      sender,
+		signer:      dkimSigner(group.Owner_ID, sender.From.Address),
+	}, nil
+}
+
+// compose builds the mail to recipient, merged with its attributes. The
+// unsubscribe link is made out to unsubscribeAs, which is the recipient
+// itself except for test sends.
+func (c *groupComposer) compose(recipient string, attributes map[string]string, unsubscribeAs string) (*mailer.Email, error) {
+	subject, body, err := c.merge.render(recipient, attributes)
+	if err != nil {
+		return nil, fmt.Errorf("error rendering message: %v", err)
+	}
+
+	unsubscribe := unsubscribeURL(c.group.Owner_ID, unsubscribeAs, c.group.Group_ID)
+	htmlText := strings.ReplaceAll(c.layout, "{{MESSAGE}}", body)
+	htmlText = strings.ReplaceAll(htmlText, "{{UNSUBSCRIBE}}", `<p><a href="`+unsubscribe+`">Unsubscribe</a></p>`)
+
+	return &mailer.Email{
+		From:    c.sender.From,
+		To:      []string{recipient},
+		ReplyTo: c.sender.ReplyTo,
+		Subject: subject,
+		HTML:    htmlText,
+		Headers: map[string]string{
+			"List-Unsubscribe":      "<" + unsubscribe + ">",
+			"List-Unsubscribe-Post": "List-Unsubscribe=One-Click",
+		},
+		Attachments: c.attachments,
+		DKIM:        c.signer,
+	}, nil
+}
+
+// createDeliveries registers every recipient of the campaign once, matching
+// addresses by recipientKey as imports do. Invalid
+// addresses are stored as failed and suppressed ones as skipped straight
+// away so they show up in the campaign report.
+func createDeliveries(campaign models.Campaign, recipients []string, suppressed map[string]string, mergePlus bool) error {
+	var deliveries []models.Delivery
+	seen := make(map[string]bool)
+	valid := 0
+	for _, recipient := range recipients {
+		recipient = strings.TrimSpace(recipient)
+		key := recipientKey(recipient, mergePlus)
+		if recipient == "" || seen[key] {
+			continue
+		}
+		seen[key] = true
+
+		delivery := models.Delivery{
+			Campaign_ID: campaign.Campaign_ID,
+			Recipient:   recipient,
+			Status:      models.DeliveryPending,
+		}
+		if reason, ok := suppressed[recipient]; ok {
+			delivery.Status = models.DeliverySkipped
+			delivery.Response = "suppressed: " + reason
+		} else if isValidEmail(recipient) {
+			valid++
+		} else {
+			delivery.Status = models.DeliveryFailed
+			delivery.Response = "invalid email address"
+		}
+		deliveries = append(deliveries, delivery)
+	}
+
+	if valid == 0 {
+		return fmt.Errorf("no valid recipients found")
+	}
+
+	return database.DB.Clauses(clause.OnConflict{DoNothing: true}).CreateInBatches(deliveries, 500).Error
+}
+
+// claimDelivery moves a pending delivery to sending. Only one worker can win
+// the update, so a campaign picked up by a second worker after a lost lease
+// doesn't mail its recipients twice.
+func claimDelivery(delivery models.Delivery) bool {
+	res := database.DB.Model(&models.Delivery{}).
+		Where("id = ? AND status = ?", delivery.ID, models.DeliveryPending).
+		Updates(map[string]interface{}{"status": models.DeliverySending, "attempted_at": time.Now()})
+	if res.Error != nil {
+		log.Println("Error claiming delivery:", res.Error)
+		return false
+	}
+	return res.RowsAffected == 1
+}
+
+// failInterruptedDeliveries settles the deliveries a previous run of the
+// campaign was sending when it stopped. Whether those mails went out is
+// unknown, and sending them again could deliver them twice.
+func failInterruptedDeliveries(campaign models.Campaign) error {
+	return database.DB.Model(&models.Delivery{}).
+		Where("campaign_id = ? AND status = ?", campaign.Campaign_ID, models.DeliverySending).
+		Updates(map[string]interface{}{"status": models.DeliveryFailed, "response": "interrupted while sending, not retried"}).Error
+}
+
+// deliveryError is how a send error is shown to users: the mail server's
+// reply, but only a generic note for connection failures, whose addresses
+// and ports are left to the log.
+func deliveryError(err error) string {
+	var reply *textproto.Error
+	var opErr *net.OpError
+	switch {
+	case errors.As(err, &reply):
+		return reply.Error()
+	case errors.Is(err, fetcher.ErrBlocked):
+		return "the mail server's address is not allowed"
+	case errors.As(err, &opErr), errors.Is(err, io.EOF), errors.Is(err, io.ErrUnexpectedEOF):
+		return "could not connect to the mail server"
+	}
+	return err.Error()
+}
+
+// recordAttempt stores the final outcome of sending to one recipient.
+func recordAttempt(delivery models.Delivery, attempts int, sendErr error) {
+	now := time.Now()
+	updates := map[string]interface{}{
+		"attempts":     gorm.Expr("attempts + ?", attempts),
+		"attempted_at": now,
+		"status":       models.DeliverySent,
+		"response":     "",
+	}
+	if sendErr != nil {
+		updates["status"] = models.DeliveryFailed
+		updates["response"] = deliveryError(sendErr)
+		if mailer.IsTransient(sendErr) {
+			updates["response"] = fmt.Sprintf("gave up after %d attempts: %s", attempts, deliveryError(sendErr))
+		}
+		log.Printf("error sending email to %s: %v", delivery.Recipient, sendErr)
+	}
+
+	if err := database.DB.Model(&models.Delivery{}).Where("id = ?", delivery.ID).Updates(updates).Error; err != nil {
+		log.Println("Error recording delivery:", err)
 	}
-	return nil
 }
@@ -3,12 +3,15 @@ package handlers
 import (
 	"encoding/json"
 	"log"
+	"mime/multipart"
 	"net/http"
 	"strings"
+	"time"
 
 	"github.com/dgrijalva/jwt-go"
 	"github.com/karan-singh-17/Quick-Mail/database"
 	"github.com/karan-singh-17/Quick-Mail/models"
+	"gorm.io/gorm"
 )
 
 type GroupData struct {
@@ -20,15 +23,29 @@ type GroupData struct {
 	CSVFilePath  string   `json:"csv_file_path,omitempty"`
 	HTMLLink     string   `json:"html_link,omitempty"`
 	HTMLFilePath string   `json:"html_path,omitempty"`
+	LiveSources  bool     `json:"live_sources,omitempty"`
+	MergePlus    bool     `json:"merge_plus_addresses,omitempty"`
+	PlainMessage bool     `json:"plain_message,omitempty"`
+	Cron         string   `json:"cron,omitempty"`
+	CronTimeZone string   `json:"cron_time_zone,omitempty"`
+	CronEndAt    string   `json:"cron_end_at,omitempty"`
+	SenderID     string   `json:"sender_id,omitempty"`
+	// ImportOptions pick the sheet and columns of csv_link, csv_file_path
+	// or csv_file.
+	ImportOptions models.ImportOptions `json:"import_options"`
+	// Attachments are sent with every mail of the group.
+	Attachments []AttachmentData `json:"attachments,omitempty"`
 }
 
 // Post Groups
 // @Summary creates a new group
-// @Description creates a new group. Make sure you are logged in and follow the parameter rules.
+// @Description creates a new group. Make sure you are logged in and follow the parameter rules. The recipients CSV and the HTML message can also be uploaded: send multipart/form-data with the group as JSON in the "group" field and the files as "csv_file" and "html_file".
 // @Tags Groups
-// @Accept json
+// @Accept json,mpfd
 // @Produce json
 // @Param Group body GroupData true "Group"
+// @Param csv_file formData file false "Recipients CSV"
+// @Param html_file formData file false "HTML message"
 // @Success 201 {object} map[string]interface{} "Successful response with group details"
 // @Failure 400 {object} string "Bad Request"
 // @Failure 401 {object} string "Unauthorized"
@@ -71,49 +88,73 @@ func CreateGroup(w http.ResponseWriter, r *http.Request) {
 	}
 
 	var data GroupData
+	var csvFile, htmlFile *multipart.FileHeader
 
-	if err := json.NewDecoder(r.Body).Decode(&data); err != nil {
+	if isMultipart(r) {
+		if csvFile, htmlFile, err = readGroupForm(w, r, &data); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+	} else if err := json.NewDecoder(r.Body).Decode(&data); err != nil {
 		http.Error(w, "Error Parsing Data", http.StatusBadRequest)
 		return
 	}
 
-	if strings.TrimSpace(data.Name) == "" || (strings.TrimSpace(data.Message) == "" && strings.TrimSpace(data.HTMLFilePath) == "" && strings.TrimSpace(data.HTMLLink) == "") || (data.Recipients == nil && strings.TrimSpace(data.CSVLink) == "" && strings.TrimSpace(data.CSVFilePath) == "") || strings.TrimSpace(data.Subject) == "" {
+	if strings.TrimSpace(data.Name) == "" || (strings.TrimSpace(data.Message) == "" && strings.TrimSpace(data.HTMLFilePath) == "" && strings.TrimSpace(data.HTMLLink) == "" && htmlFile == nil) || (data.Recipients == nil && strings.TrimSpace(data.CSVLink) == "" && strings.TrimSpace(data.CSVFilePath) == "" && csvFile == nil) || strings.TrimSpace(data.Subject) == "" {
 		http.Error(w, "Wrong Inputs... Please refer the docs", http.StatusBadRequest)
 		return
 	}
 
-	if !validateSingleFilledField(data.Message, data.HTMLFilePath, data.HTMLLink) {
-		http.Error(w, "Only one of Message, HTMLFilePath, or HTMLLink can be provided", http.StatusBadRequest)
+	if !validateSingleFilledField(data.Message, data.HTMLFilePath, data.HTMLLink, uploadName(htmlFile)) {
+		http.Error(w, "Only one of Message, HTMLFilePath, HTMLLink or html_file can be provided", http.StatusBadRequest)
 		return
 	}
 
-	if !validateSingleFilledField(data.CSVFilePath, data.CSVLink) {
-		http.Error(w, "Only one of CSVFilePath or CSVLink can be provided", http.StatusBadRequest)
+	if !validateSingleFilledField(data.CSVFilePath, data.CSVLink, uploadName(csvFile)) {
+		http.Error(w, "Only one of CSVFilePath, CSVLink or csv_file can be provided", http.StatusBadRequest)
 		return
 	}
 
+	var imported []importedRecipient
+
 	if strings.TrimSpace(data.CSVFilePath) != "" {
-		fileRecipients, err := extractRecipientsFromFilePath(data.CSVFilePath)
+		fileRecipients, err := extractRecipientsFromFilePath(data.CSVFilePath, data.ImportOptions)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		imported = append(imported, fileRecipients...)
+	}
+
+	if csvFile != nil {
+		uploaded, err := readCSVUpload(csvFile, data.ImportOptions)
 		if err != nil {
-			http.Error(w, "Error reading CSV file from path", http.StatusInternalServerError)
+			http.Error(w, err.Error(), http.StatusBadRequest)
 			return
 		}
-		data.Recipients = append(data.Recipients, fileRecipients...)
+		imported = append(imported, uploaded...)
 	}
 
 	if strings.TrimSpace(data.CSVLink) != "" {
-		csvRecipients, err := fetchRecipientsFromCSV(data.CSVLink)
+		csvRecipients, err := fetchRecipientsFromCSV(data.CSVLink, data.ImportOptions)
 		if err != nil {
-			http.Error(w, "Error fetching recipients from CSV", http.StatusInternalServerError)
+			fetchFailed(w, "Error fetching recipients from CSV", err)
 			return
 		}
-		data.Recipients = append(data.Recipients, csvRecipients...)
+		for i := range csvRecipients {
+			csvRecipients[i].Source = models.RecipientSourceCSV
+		}
+		imported = append(imported, csvRecipients...)
+	}
+
+	for _, email := range data.Recipients {
+		imported = append(imported, importedRecipient{Email: email})
 	}
 
 	if strings.TrimSpace(data.HTMLLink) != "" {
 		htmlContent, err := fetchHTMLFromLink(data.HTMLLink)
 		if err != nil {
-			http.Error(w, "Error fetching HTML from link", http.StatusInternalServerError)
+			fetchFailed(w, "Error fetching HTML from link", err)
 			return
 		}
 		data.Message = htmlContent
@@ -122,26 +163,84 @@ func CreateGroup(w http.ResponseWriter, r *http.Request) {
 	if strings.TrimSpace(data.HTMLFilePath) != "" {
 		htmlContent, err := readHTMLFromFilePath(data.HTMLFilePath)
 		if err != nil {
-			http.Error(w, "Error reading HTML from file path", http.StatusInternalServerError)
+			http.Error(w, err.Error(), http.StatusBadRequest)
 			return
 		}
 		data.Message = htmlContent
 	}
+
+	if htmlFile != nil {
+		htmlContent, err := readHTMLUpload(htmlFile)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		data.Message = htmlContent
+	}
+
+	if !data.PlainMessage {
+		if _, err := parseMergeTemplate(data.Subject, data.Message); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+	}
+
+	if err := checkSender(user.Id, data.SenderID); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	var attachments []models.Attachment
+	for _, attachmentData := range data.Attachments {
+		attachment, err := attachmentData.load()
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		attachments = append(attachments, attachment)
+	}
+	if err := checkAttachments(nil, attachments); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
 	tokenid, err := generateToken()
 	if err != nil {
 		panic(err)
 	}
 
 	group := models.Group{
-		Group_ID:   "g-" + tokenid,
-		Name:       data.Name,
-		Owner_ID:   user.Id,
-		Recipients: strings.Join(data.Recipients, ","),
-		Subject:    data.Subject,
-		Message:    data.Message,
+		Group_ID:           "g-" + tokenid,
+		Name:               data.Name,
+		Owner_ID:           user.Id,
+		Subject:            data.Subject,
+		Message:            data.Message,
+		Sender_ID:          data.SenderID,
+		CSVLink:            strings.TrimSpace(data.CSVLink),
+		HTMLLink:           strings.TrimSpace(data.HTMLLink),
+		LiveSources:        data.LiveSources,
+		MergePlusAddresses: data.MergePlus,
+		PlainMessage:       data.PlainMessage,
+	}
+
+	if err := applyCronSchedule(&group, data.Cron, data.CronTimeZone, data.CronEndAt); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
 	}
 
-	if err := database.DB.Create(&group).Error; err != nil {
+	var merged int64
+	err = database.DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Create(&group).Error; err != nil {
+			return err
+		}
+		if err := saveAttachments(tx, group.Group_ID, attachments); err != nil {
+			return err
+		}
+		added, m, err := saveRecipients(tx, group, imported)
+		group.RecipientCount, merged = added, m
+		return err
+	})
+	if err != nil {
 		http.Error(w, "Error creating group", http.StatusInternalServerError)
 		return
 	}
@@ -149,6 +248,7 @@ func CreateGroup(w http.ResponseWriter, r *http.Request) {
 		"status":  http.StatusCreated,
 		"message": "Group created",
 		"group":   group,
+		"merged":  merged,
 	}
 
 	w.Header().Set("Content-Type", "application/json")
@@ -182,6 +282,11 @@ func GetAllGroups(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	if err := fillRecipientCounts(groups); err != nil {
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+
 	// Respond with the list of groups as JSON
 	w.Header().Set("Content-Type", "application/json")
 	if err := json.NewEncoder(w).Encode(groups); err != nil {
@@ -192,11 +297,13 @@ func GetAllGroups(w http.ResponseWriter, r *http.Request) {
 
 // Edit Group
 // @Summary edit an existing group
-// @Description edits the details of a group. Make sure you are logged in and are the owner of the group.
+// @Description edits the details of a group. Make sure you are logged in and are the owner of the group. A new csv_link or html_link is fetched right away and replaces the linked recipients or the message, an empty one unlinks the group; a new message unlinks html_link. As multipart/form-data, the fields go in the "group" field as JSON; an uploaded "csv_file" replaces the recipients and an "html_file" the message.
 // @Tags Groups
-// @Accept json
+// @Accept json,mpfd
 // @Produce json
 // @Param Group body models.Group true "Group"
+// @Param csv_file formData file false "Recipients CSV"
+// @Param html_file formData file false "HTML message"
 // @Success 200 {object} map[string]string "message"
 // @Router /api/group/edit-group [put]
 // @security jwt_token
@@ -207,8 +314,15 @@ func EditGroup(w http.ResponseWriter, r *http.Request) {
 	}
 
 	var data map[string]string
+	var csvFile, htmlFile *multipart.FileHeader
 
-	if err := json.NewDecoder(r.Body).Decode(&data); err != nil {
+	if isMultipart(r) {
+		var err error
+		if csvFile, htmlFile, err = readGroupForm(w, r, &data); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+	} else if err := json.NewDecoder(r.Body).Decode(&data); err != nil {
 		http.Error(w, "Invalid input", http.StatusBadRequest)
 		return
 	}
@@ -230,12 +344,101 @@ func EditGroup(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	if options, ok := data["import_options"]; ok {
+		grp.ImportOptions = models.ImportOptions{}
+		if strings.TrimSpace(options) != "" {
+			if err := json.Unmarshal([]byte(options), &grp.ImportOptions); err != nil {
+				http.Error(w, "Invalid import_options", http.StatusBadRequest)
+				return
+			}
+		}
+	}
+
+	var uploaded []importedRecipient
+	if csvFile != nil {
+		if _, ok := data["recipients"]; ok {
+			http.Error(w, "Only one of recipients or csv_file can be provided", http.StatusBadRequest)
+			return
+		}
+		if uploaded, err = readCSVUpload(csvFile, grp.ImportOptions); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+	}
+	if htmlFile != nil {
+		if _, ok := data["message"]; ok {
+			http.Error(w, "Only one of message or html_file can be provided", http.StatusBadRequest)
+			return
+		}
+		if data["message"], err = readHTMLUpload(htmlFile); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+	}
+
+	// A new csv_link or html_link is fetched right away and replaces the
+	// linked recipients or the message; an empty one unlinks the group.
+	// The source caches of changed links are dropped below.
+	var changedSources []string
+	var sourceCaches []models.SourceCache
+	var linked []importedRecipient
+	if link, ok := data["csv_link"]; ok {
+		if _, conflict := data["recipients"]; conflict || csvFile != nil {
+			http.Error(w, "Only one of recipients, csv_file or csv_link can be provided", http.StatusBadRequest)
+			return
+		}
+		if link = strings.TrimSpace(link); link != grp.CSVLink {
+			grp.CSVLink = link
+			changedSources = append(changedSources, models.SourceCSV)
+			if link != "" {
+				body, cache, _, err := fetchSource(grp.Group_ID, models.SourceCSV, recipientsLink(link, grp.ImportOptions), importOptionsHash(grp.ImportOptions))
+				if err != nil {
+					fetchFailed(w, "Error fetching recipients from CSV", err)
+					return
+				}
+				if linked, err = readRecipients(body, grp.ImportOptions); err != nil {
+					http.Error(w, err.Error(), http.StatusBadRequest)
+					return
+				}
+				if len(linked) == 0 {
+					http.Error(w, "csv has no recipients", http.StatusBadRequest)
+					return
+				}
+				for i := range linked {
+					linked[i].Source = models.RecipientSourceCSV
+				}
+				sourceCaches = append(sourceCaches, cache)
+			}
+		}
+	}
+	if link, ok := data["html_link"]; ok {
+		if _, conflict := data["message"]; conflict {
+			http.Error(w, "Only one of message, html_file or html_link can be provided", http.StatusBadRequest)
+			return
+		}
+		if link = strings.TrimSpace(link); link != grp.HTMLLink {
+			grp.HTMLLink = link
+			changedSources = append(changedSources, models.SourceHTML)
+			if link != "" {
+				body, cache, _, err := fetchSource(grp.Group_ID, models.SourceHTML, link, "")
+				if err != nil {
+					fetchFailed(w, "Error fetching HTML from link", err)
+					return
+				}
+				data["message"] = string(body)
+				sourceCaches = append(sourceCaches, cache)
+			}
+		}
+	} else if _, ok := data["message"]; ok && grp.HTMLLink != "" {
+		// Otherwise the next refresh of the link would overwrite the
+		// message that was just set.
+		grp.HTMLLink = ""
+		changedSources = append(changedSources, models.SourceHTML)
+	}
+
 	if name, ok := data["name"]; ok {
 		grp.Name = name
 	}
-	if recipients, ok := data["recipients"]; ok {
-		grp.Recipients = recipients
-	}
 	if subject, ok := data["subject"]; ok {
 		grp.Subject = subject
 	}
@@ -243,18 +446,101 @@ func EditGroup(w http.ResponseWriter, r *http.Request) {
 		grp.Message = message
 	}
 
-	if err := database.DB.Save(&grp).Error; err != nil {
+	if plain, ok := data["plain_message"]; ok {
+		grp.PlainMessage = plain == "true"
+	}
+	if _, err := groupTemplate(grp); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	if senderID, ok := data["sender_id"]; ok {
+		if err := checkSender(grp.Owner_ID, senderID); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		grp.Sender_ID = senderID
+	}
+
+	if live, ok := data["live_sources"]; ok {
+		grp.LiveSources = live == "true"
+	}
+
+	if mergePlus, ok := data["merge_plus_addresses"]; ok {
+		grp.MergePlusAddresses = mergePlus == "true"
+	}
+
+	_, hasCron := data["cron"]
+	_, hasTimeZone := data["cron_time_zone"]
+	_, hasEndAt := data["cron_end_at"]
+	if hasCron || hasTimeZone || hasEndAt {
+		if err := editCronSchedule(&grp, data); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+	}
+
+	var merged int64
+	err = database.DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Save(&grp).Error; err != nil {
+			return err
+		}
+		if len(changedSources) > 0 {
+			if err := tx.Where("group_id = ? AND kind IN ?", grp.Group_ID, changedSources).Delete(&models.SourceCache{}).Error; err != nil {
+				return err
+			}
+		}
+		for _, cache := range sourceCaches {
+			if err := tx.Save(&cache).Error; err != nil {
+				return err
+			}
+		}
+
+		// Recipients of a new csv_link replace the ones of the old link
+		// and keep the ones added by hand, as a refresh does.
+		if linked != nil {
+			if err := tx.Where("group_id = ? AND source = ?", grp.Group_ID, models.RecipientSourceCSV).Delete(&models.Recipient{}).Error; err != nil {
+				return err
+			}
+			_, merged, err = saveRecipients(tx, grp, linked)
+			return err
+		}
+
+		// A recipients string or an uploaded CSV still replaces the whole
+		// membership.
+		recipients, ok := data["recipients"]
+		if !ok && csvFile == nil {
+			return nil
+		}
+		if err := tx.Where("group_id = ?", grp.Group_ID).Delete(&models.Recipient{}).Error; err != nil {
+			return err
+		}
+		replacement := uploaded
+		if ok {
+			for _, email := range strings.Split(recipients, ",") {
+				replacement = append(replacement, importedRecipient{Email: email})
+			}
+		}
+		_, merged, err = saveRecipients(tx, grp, replacement)
+		return err
+	})
+	if err != nil {
 		http.Error(w, "Error updating group", http.StatusInternalServerError)
 		return
 	}
 
+	response := map[string]interface{}{"message": "Group updated successfully"}
+	if _, ok := data["recipients"]; ok || csvFile != nil || linked != nil {
+		response["merged"] = merged
+	}
+
 	w.WriteHeader(http.StatusOK)
-	json.NewEncoder(w).Encode(map[string]string{"message": "Group updated successfully"})
+	json.NewEncoder(w).Encode(response)
 }
 
 // Delete Group
 // @Summary delete a group
-// @Description deletes an existing group. Make sure you are logged in and are the owner of the group.
+// @Description deletes an existing group along with its recipients and attachments, and cancels its campaigns that haven't started yet. Make sure you are logged in and are the owner of the group.
 // @Tags Groups
 // @Accept json
 // @Produce json
@@ -292,7 +578,29 @@ func DeleteGroup(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	if err := database.DB.Delete(grp).Error; err != nil {
+	err = database.DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Where("group_id = ?", grp.Group_ID).Delete(&models.Recipient{}).Error; err != nil {
+			return err
+		}
+		if err := tx.Where("group_id = ?", grp.Group_ID).Delete(&models.Attachment{}).Error; err != nil {
+			return err
+		}
+		if err := tx.Where("group_id = ?", grp.Group_ID).Delete(&models.SourceCache{}).Error; err != nil {
+			return err
+		}
+		if err := tx.Where("group_id = ?", grp.Group_ID).Delete(&models.PendingImport{}).Error; err != nil {
+			return err
+		}
+		// Campaigns that haven't started would otherwise be claimed for a
+		// group that no longer exists; finished ones stay for the record.
+		if err := tx.Model(&models.Campaign{}).
+			Where("group_id = ? AND status IN ?", grp.Group_ID, []string{models.CampaignScheduled, models.CampaignQueued}).
+			Updates(map[string]interface{}{"status": models.CampaignCancelled, "error": "group deleted", "finished_at": time.Now()}).Error; err != nil {
+			return err
+		}
+		return tx.Delete(grp).Error
+	})
+	if err != nil {
 		http.Error(w, "Unable to delete group", http.StatusInternalServerError)
 		return
 	}
@@ -300,3 +608,26 @@ func DeleteGroup(w http.ResponseWriter, r *http.Request) {
 	w.WriteHeader(http.StatusOK)
 	json.NewEncoder(w).Encode(map[string]string{"message": "Group deleted successfully"})
 }
+
+// ownedGroup loads the group named in the request path and makes sure the
+// current user owns it. It writes the error response itself and reports
+// whether the handler may continue.
+func ownedGroup(w http.ResponseWriter, r *http.Request) (models.Group, models.User, bool) {
+	curr_user, err := GetUser(w, r)
+	if err != nil {
+		return models.Group{}, models.User{}, false
+	}
+
+	var grp models.Group
+	if err := database.DB.Where("group_id = ?", r.PathValue("id")).First(&grp).Error; err != nil {
+		http.Error(w, "Group Not Found", http.StatusNotFound)
+		return models.Group{}, models.User{}, false
+	}
+
+	if grp.Owner_ID != curr_user.Id {
+		http.Error(w, "You are not the owner of this group. Access Denied", http.StatusUnauthorized)
+		return models.Group{}, models.User{}, false
+	}
+
+	return grp, curr_user, true
+}
@@ -0,0 +1,475 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/karan-singh-17/Quick-Mail/database"
+	"github.com/karan-singh-17/Quick-Mail/models"
+	"gorm.io/gorm"
+)
+
+// Previewed imports are kept for pendingImportTTL so they can be committed
+// without uploading or fetching the list again. Every user keeps at most
+// maxPendingImports of them, taking maxPendingImportSize bytes together; a
+// new preview pushes out the user's oldest ones.
+var (
+	maxPendingImports    = envInt("maxPendingImports", 5)
+	maxPendingImportSize = int64(envInt("maxPendingImportSize", 25<<20))
+)
+
+const (
+	pendingImportTTL  = 30 * time.Minute
+	maxPreviewRows    = 100
+	maxReportedErrors = 1000
+)
+
+var errImportTooLarge = errors.New("recipient list is too large to keep for an import")
+
+// storePendingImport keeps a previewed list and sets its import id and
+// expiry. A list previewed again under its import id only gets the new
+// options and a new expiry.
+func storePendingImport(pending *models.PendingImport) error {
+	now := time.Now()
+	pending.ExpiresAt = now.Add(pendingImportTTL)
+	if pending.Import_ID != "" {
+		return database.DB.Model(&models.PendingImport{}).Where("import_id = ?", pending.Import_ID).
+			Select("options", "expires_at").Updates(&models.PendingImport{Options: pending.Options, ExpiresAt: pending.ExpiresAt}).Error
+	}
+
+	pending.Size = int64(len(pending.Data))
+	if pending.Size > maxPendingImportSize {
+		return errImportTooLarge
+	}
+	tokenid, err := generateToken()
+	if err != nil {
+		return err
+	}
+	pending.Import_ID = "i-" + tokenid
+
+	return database.DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Where("expires_at < ?", now).Delete(&models.PendingImport{}).Error; err != nil {
+			return err
+		}
+
+		var kept []models.PendingImport
+		if err := tx.Select("import_id, size").Where("owner_id = ?", pending.Owner_ID).
+			Order("created_at DESC").Find(&kept).Error; err != nil {
+			return err
+		}
+		count, total := 1, pending.Size
+		var evicted []string
+		for _, p := range kept {
+			if len(evicted) == 0 && count < maxPendingImports && total+p.Size <= maxPendingImportSize {
+				count++
+				total += p.Size
+				continue
+			}
+			evicted = append(evicted, p.Import_ID)
+		}
+		if len(evicted) > 0 {
+			if err := tx.Where("import_id IN ?", evicted).Delete(&models.PendingImport{}).Error; err != nil {
+				return err
+			}
+		}
+		return tx.Create(pending).Error
+	})
+}
+
+// loadPendingImport returns a previewed list of the group, if it hasn't
+// expired.
+func loadPendingImport(id string, grp models.Group) (models.PendingImport, bool) {
+	var pending models.PendingImport
+	err := database.DB.Where("import_id = ? AND group_id = ? AND owner_id = ? AND expires_at > ?", id, grp.Group_ID, grp.Owner_ID, time.Now()).
+		First(&pending).Error
+	return pending, err == nil
+}
+
+// ImportPreviewData names the list to preview: an earlier preview's
+// import_id, a url, a path in the import directory, or (with none of them
+// and no upload) the group's csv_link.
+type ImportPreviewData struct {
+	ImportID      string                `json:"import_id,omitempty"`
+	URL           string                `json:"url,omitempty"`
+	Path          string                `json:"path,omitempty"`
+	ImportOptions *models.ImportOptions `json:"import_options,omitempty"`
+	Rows          int                   `json:"rows,omitempty"`
+}
+
+type ImportCommitData struct {
+	ImportID      string                `json:"import_id"`
+	ImportOptions *models.ImportOptions `json:"import_options,omitempty"`
+	// Mode is "append" (default) to add to the group or "replace" to
+	// replace its recipients.
+	Mode string `json:"mode,omitempty"`
+}
+
+// importRowResult is one row of an import as it would be or was imported.
+type importRowResult struct {
+	Row        int               `json:"row"`
+	Email      string            `json:"email,omitempty"`
+	Attributes map[string]string `json:"attributes,omitempty"`
+	Error      string            `json:"error,omitempty"`
+}
+
+// checkImportRows normalizes the addresses of the rows and flags the ones
+// that won't be imported: no or an invalid address, or one that matches an
+// earlier row or a member of the group (see recipientKey).
+func checkImportRows(rows []importRow, mergePlus bool, existing map[string]bool) []importRowResult {
+	results := make([]importRowResult, len(rows))
+	firstRow := make(map[string]int)
+	for i, row := range rows {
+		email := normalizeEmail(row.Recipient.Email)
+		result := importRowResult{Row: row.Row, Email: email, Attributes: row.Recipient.Attributes}
+		key := recipientKey(email, mergePlus)
+		switch {
+		case email == "":
+			result.Error = "missing email"
+		case !isValidEmail(email):
+			result.Error = "invalid email address"
+		case firstRow[key] != 0:
+			result.Error = "duplicate of row " + strconv.Itoa(firstRow[key])
+		case existing[key]:
+			result.Error = "already in the group"
+		default:
+			firstRow[key] = row.Row
+		}
+		results[i] = result
+	}
+	return results
+}
+
+func importRowEmails(rows []importRow) []string {
+	emails := make([]string, len(rows))
+	for i, row := range rows {
+		emails[i] = row.Recipient.Email
+	}
+	return emails
+}
+
+// effectiveOptions returns the options an import was read with, sniffed
+// values filled in, so committing reads the list exactly as previewed.
+func effectiveOptions(options models.ImportOptions, format listFormat, mapping columnMapping) models.ImportOptions {
+	if format.Format == "csv" {
+		options.Encoding = format.Encoding
+		options.Delimiter = format.Delimiter
+	}
+	header := mapping.Header
+	options.Header = &header
+	if mapping.EmailCol >= 0 && mapping.EmailCol < len(mapping.Columns) {
+		options.EmailColumn = mapping.Columns[mapping.EmailCol]
+	}
+	options.Columns = make(map[string]string)
+	for j, field := range mapping.Fields {
+		if field != "" {
+			options.Columns[mapping.Columns[j]] = field
+		}
+	}
+	return options
+}
+
+// readPreviewSource loads the list a preview request names. It writes the
+// error response itself and reports whether the list could be read.
+func readPreviewSource(w http.ResponseWriter, r *http.Request, grp models.Group) (models.PendingImport, ImportPreviewData, bool) {
+	var data ImportPreviewData
+	pending := models.PendingImport{Owner_ID: grp.Owner_ID, Group_ID: grp.Group_ID}
+
+	if isMultipart(r) {
+		r.Body = http.MaxBytesReader(w, r.Body, maxCSVUploadSize+1<<20)
+		file, header, err := r.FormFile("file")
+		if err != nil {
+			http.Error(w, "file is missing", http.StatusBadRequest)
+			return pending, data, false
+		}
+		defer file.Close()
+		if options := r.FormValue("import_options"); options != "" {
+			data.ImportOptions = &models.ImportOptions{}
+			if err := json.Unmarshal([]byte(options), data.ImportOptions); err != nil {
+				http.Error(w, "Invalid import_options", http.StatusBadRequest)
+				return pending, data, false
+			}
+		}
+		data.Rows, _ = strconv.Atoi(r.FormValue("rows"))
+		if pending.Data, err = readRecipientList(file, header.Filename, optionsOf(data.ImportOptions)); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return pending, data, false
+		}
+		return pending, data, true
+	}
+
+	if r.ContentLength != 0 {
+		if err := json.NewDecoder(r.Body).Decode(&data); err != nil {
+			http.Error(w, "Invalid Input", http.StatusBadRequest)
+			return pending, data, false
+		}
+	}
+	options := optionsOf(data.ImportOptions)
+
+	switch {
+	case data.ImportID != "":
+		stored, ok := loadPendingImport(data.ImportID, grp)
+		if !ok {
+			http.Error(w, "Import not found or expired", http.StatusNotFound)
+			return pending, data, false
+		}
+		pending = stored
+	case strings.TrimSpace(data.Path) != "":
+		path, err := importPath(data.Path)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return pending, data, false
+		}
+		file, err := os.Open(path)
+		if err != nil {
+			http.Error(w, "Error opening the recipient list", http.StatusBadRequest)
+			return pending, data, false
+		}
+		defer file.Close()
+		if pending.Data, err = readRecipientList(file, path, options); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return pending, data, false
+		}
+	default:
+		link := strings.TrimSpace(data.URL)
+		if link == "" {
+			link = grp.CSVLink
+		}
+		if link == "" {
+			http.Error(w, "Upload a file or send a url, path or import_id", http.StatusBadRequest)
+			return pending, data, false
+		}
+		body, err := fetchRecipientList(link, options)
+		if err != nil {
+			fetchFailed(w, "Error fetching the recipient list", err)
+			return pending, data, false
+		}
+		pending.Data, pending.Link = body, link
+	}
+	return pending, data, true
+}
+
+func optionsOf(options *models.ImportOptions) models.ImportOptions {
+	if options == nil {
+		return models.ImportOptions{}
+	}
+	return *options
+}
+
+// Preview Import
+// @Summary preview a recipient list import
+// @Description reads a CSV, XLSX or ODS list of recipients without importing it: sniffs the encoding, delimiter and header row, suggests which column holds the email address and which hold merge fields, and shows the first rows as they would be imported, with the reason for every row that would be skipped. Upload the list as multipart/form-data ("file", optional "import_options" as JSON and "rows"), or send JSON with a url, a path in the import directory, or the import_id of an earlier preview to try other import_options. Without any of them the group's csv_link is read. The returned import_id and import_options commit the import. Make sure you are logged in and are the owner of the group.
+// @Tags Recipients
+// @Accept json,mpfd
+// @Produce json
+// @Param id path string true "Group ID"
+// @Param preview body ImportPreviewData false "List to preview and how to read it"
+// @Param file formData file false "CSV, XLSX or ODS file"
+// @Success 200 {object} map[string]interface{} "import_id, detected format and mapping, rows and preview"
+// @Failure 400 {object} string "Invalid list"
+// @Failure 401 {object} string "Unauthorized"
+// @Failure 404 {object} string "Group Not Found"
+// @Failure 413 {object} string "List too large to keep"
+// @Router /api/group/{id}/import/preview [post]
+// @security jwt_token
+func PreviewImport(w http.ResponseWriter, r *http.Request) {
+	grp, _, ok := ownedGroup(w, r)
+	if !ok {
+		return
+	}
+
+	pending, data, ok := readPreviewSource(w, r, grp)
+	if !ok {
+		return
+	}
+	options := optionsOf(data.ImportOptions)
+
+	records, format, err := readRecords(pending.Data, options)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	mapping, err := mapColumns(records, options)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	rows := mapRows(records, mapping)
+	existing, err := existingKeys(database.DB, grp, importRowEmails(rows))
+	if err != nil {
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+	results := checkImportRows(rows, grp.MergePlusAddresses, existing)
+	valid := 0
+	for _, result := range results {
+		if result.Error == "" {
+			valid++
+		}
+	}
+
+	pending.Options = effectiveOptions(options, format, mapping)
+	err = storePendingImport(&pending)
+	if errors.Is(err, errImportTooLarge) {
+		http.Error(w, err.Error(), http.StatusRequestEntityTooLarge)
+		return
+	}
+	if err != nil {
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+
+	n := data.Rows
+	if n <= 0 {
+		n = 10
+	}
+	n = min(n, maxPreviewRows)
+
+	response := map[string]interface{}{
+		"status":         http.StatusOK,
+		"import_id":      pending.Import_ID,
+		"expires_at":     pending.ExpiresAt,
+		"format":         format.Format,
+		"encoding":       format.Encoding,
+		"delimiter":      format.Delimiter,
+		"sheets":         format.Sheets,
+		"header":         mapping.Header,
+		"columns":        mapping.Columns,
+		"import_options": pending.Options,
+		"rows":           records[:min(len(records), n)],
+		"preview":        results[:min(len(results), n)],
+		"total_rows":     len(results),
+		"valid_rows":     valid,
+		"skipped_rows":   len(results) - valid,
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(response)
+}
+
+// Commit Import
+// @Summary import a previewed recipient list
+// @Description imports the list of an earlier preview into the group, read with the import_options returned by the preview or the ones given here. mode "append" (default) adds the recipients, "replace" replaces the group's recipients. The response reports every row that was skipped and why. When the list is the group's csv_link, the options are kept for later refreshes of the link. Make sure you are logged in and are the owner of the group.
+// @Tags Recipients
+// @Accept json
+// @Produce json
+// @Param id path string true "Group ID"
+// @Param commit body ImportCommitData true "Import to commit"
+// @Success 200 {object} map[string]interface{} "added, merged and the skipped rows"
+// @Failure 400 {object} string "Invalid Input"
+// @Failure 401 {object} string "Unauthorized"
+// @Failure 404 {object} string "Import not found or expired"
+// @Router /api/group/{id}/import/commit [post]
+// @security jwt_token
+func CommitImport(w http.ResponseWriter, r *http.Request) {
+	grp, _, ok := ownedGroup(w, r)
+	if !ok {
+		return
+	}
+
+	var data ImportCommitData
+	if err := json.NewDecoder(r.Body).Decode(&data); err != nil || data.ImportID == "" {
+		http.Error(w, "Invalid Input", http.StatusBadRequest)
+		return
+	}
+	if data.Mode == "" {
+		data.Mode = "append"
+	}
+	if data.Mode != "append" && data.Mode != "replace" {
+		http.Error(w, "mode must be append or replace", http.StatusBadRequest)
+		return
+	}
+
+	pending, found := loadPendingImport(data.ImportID, grp)
+	if !found {
+		http.Error(w, "Import not found or expired", http.StatusNotFound)
+		return
+	}
+	options := pending.Options
+	if data.ImportOptions != nil {
+		options = *data.ImportOptions
+	}
+
+	records, _, err := readRecords(pending.Data, options)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	mapping, err := mapColumns(records, options)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	rows := mapRows(records, mapping)
+
+	// Only a list read from the group's own link is replaced when the link
+	// is refreshed, so only that one is marked as coming from it.
+	fromLink := pending.Link != "" && pending.Link == grp.CSVLink
+	source := ""
+	if fromLink {
+		source = models.RecipientSourceCSV
+	}
+
+	var added, merged int64
+	var results []importRowResult
+	err = database.DB.Transaction(func(tx *gorm.DB) error {
+		existing := map[string]bool{}
+		if data.Mode == "replace" {
+			if err := tx.Where("group_id = ?", grp.Group_ID).Delete(&models.Recipient{}).Error; err != nil {
+				return err
+			}
+		} else if existing, err = existingKeys(tx, grp, importRowEmails(rows)); err != nil {
+			return err
+		}
+
+		results = checkImportRows(rows, grp.MergePlusAddresses, existing)
+		var recipients []importedRecipient
+		for _, result := range results {
+			if result.Error == "" {
+				recipients = append(recipients, importedRecipient{Email: result.Email, Attributes: result.Attributes, Source: source})
+			}
+		}
+		if added, merged, err = saveRecipients(tx, grp, recipients); err != nil {
+			return err
+		}
+
+		if err := tx.Where("import_id = ?", pending.Import_ID).Delete(&models.PendingImport{}).Error; err != nil {
+			return err
+		}
+		if fromLink {
+			grp.ImportOptions = options
+			return tx.Save(&grp).Error
+		}
+		return nil
+	})
+	if err != nil {
+		http.Error(w, "Error importing recipients", http.StatusInternalServerError)
+		return
+	}
+
+	skipped := []importRowResult{}
+	for _, result := range results {
+		if result.Error != "" {
+			result.Attributes = nil
+			skipped = append(skipped, result)
+		}
+	}
+
+	response := map[string]interface{}{
+		"status":       http.StatusOK,
+		"mode":         data.Mode,
+		"total_rows":   len(results),
+		"added":        added,
+		"merged":       merged,
+		"skipped_rows": len(skipped),
+		"errors":       skipped[:min(len(skipped), maxReportedErrors)],
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(response)
+}
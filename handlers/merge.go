@@ -0,0 +1,380 @@
+package handlers
+
+import (
+	"bytes"
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"html"
+	"sort"
+	"strings"
+	"text/template"
+	"unicode"
+
+	"github.com/karan-singh-17/Quick-Mail/models"
+	"github.com/karan-singh-17/Quick-Mail/spreadsheet"
+)
+
+// importedRecipient is one row of a recipient import: the address plus any
+// extra columns, keyed by their merge field name (first_name -> FirstName).
+type importedRecipient struct {
+	Email      string
+	Attributes map[string]string
+	Source     string
+}
+
+var emailHeaders = map[string]bool{
+	"email":         true,
+	"e-mail":        true,
+	"email address": true,
+	"email_address": true,
+	"emailaddress":  true,
+	"mail":          true,
+}
+
+// errBadImport marks recipient lists that can't be read as asked, so they
+// are reported to the user rather than as server errors.
+var errBadImport = errors.New("invalid recipient list")
+
+// listFormat describes how a recipient list was read.
+type listFormat struct {
+	Format    string   `json:"format"`
+	Encoding  string   `json:"encoding,omitempty"`
+	Delimiter string   `json:"delimiter,omitempty"`
+	Sheets    []string `json:"sheets,omitempty"`
+}
+
+// readRecords splits a recipient list into rows: a CSV in any of the common
+// encodings and delimiters (sniffed unless options set them), or one sheet
+// of an XLSX / ODS workbook.
+func readRecords(data []byte, options models.ImportOptions) ([][]string, listFormat, error) {
+	if spreadsheet.IsSpreadsheet(data) {
+		format, err := spreadsheet.Format(data)
+		if err != nil {
+			return nil, listFormat{}, fmt.Errorf("%w: %v", errBadImport, err)
+		}
+		sheets, _ := spreadsheet.Sheets(data)
+		records, err := spreadsheet.Read(data, options.Sheet)
+		if err != nil {
+			return nil, listFormat{}, fmt.Errorf("%w: %v", errBadImport, err)
+		}
+		return records, listFormat{Format: format, Sheets: sheets}, nil
+	}
+
+	text, encoding, err := decodeText(data, options.Encoding)
+	if err != nil {
+		return nil, listFormat{}, err
+	}
+	text = strings.TrimPrefix(text, "\ufeff")
+	delimiter, err := parseDelimiter(options.Delimiter)
+	if err != nil {
+		return nil, listFormat{}, err
+	}
+	if delimiter == 0 {
+		delimiter = sniffDelimiter(text)
+	}
+
+	reader := csv.NewReader(strings.NewReader(text))
+	reader.Comma = delimiter
+	reader.FieldsPerRecord = -1
+	reader.TrimLeadingSpace = true
+	records, err := reader.ReadAll()
+	if err != nil {
+		return nil, listFormat{}, fmt.Errorf("%w: %v", errBadImport, err)
+	}
+	return records, listFormat{Format: "csv", Encoding: encoding, Delimiter: delimiterName(delimiter)}, nil
+}
+
+// readRecipients parses a recipient list (see readRecords and mapColumns)
+// into recipients, skipping rows without an address.
+func readRecipients(data []byte, options models.ImportOptions) ([]importedRecipient, error) {
+	records, _, err := readRecords(data, options)
+	if err != nil {
+		return nil, err
+	}
+	mapping, err := mapColumns(records, options)
+	if err != nil {
+		return nil, err
+	}
+
+	var recipients []importedRecipient
+	for _, row := range mapRows(records, mapping) {
+		if row.Recipient.Email != "" {
+			recipients = append(recipients, row.Recipient)
+		}
+	}
+	return recipients, nil
+}
+
+// columnMapping says which column of a recipient list holds what.
+type columnMapping struct {
+	Header   bool
+	Columns  []string // column names: the header cells, or "Column 1", ...
+	EmailCol int
+	Fields   []string // merge field of each column, "" to leave it out
+}
+
+// mapColumns works out the layout of a recipient list. Unless options say
+// otherwise, the first row is a header when it names an email column, or
+// holds no address while the rows below do; the email column is the one
+// named so, else the one with the most addresses; and every other named
+// column becomes a merge field.
+func mapColumns(records [][]string, options models.ImportOptions) (columnMapping, error) {
+	mapping := columnMapping{EmailCol: -1}
+	if len(records) == 0 {
+		return mapping, nil
+	}
+
+	switch {
+	case options.Header != nil:
+		mapping.Header = *options.Header
+	case options.EmailColumn != "" || len(options.Columns) > 0:
+		mapping.Header = true
+	default:
+		mapping.Header = looksLikeHeader(records)
+	}
+
+	width := 0
+	for _, record := range records {
+		width = max(width, len(record))
+	}
+	mapping.Columns = make([]string, width)
+	for i := range mapping.Columns {
+		if mapping.Header && i < len(records[0]) && strings.TrimSpace(records[0][i]) != "" {
+			mapping.Columns[i] = strings.TrimSpace(records[0][i])
+		} else {
+			mapping.Columns[i] = fmt.Sprintf("Column %d", i+1)
+		}
+	}
+	find := func(name string) int {
+		for i, column := range mapping.Columns {
+			if strings.EqualFold(column, strings.TrimSpace(name)) {
+				return i
+			}
+		}
+		return -1
+	}
+
+	rows := records
+	if mapping.Header {
+		rows = records[1:]
+	}
+	switch {
+	case options.EmailColumn != "":
+		if mapping.EmailCol = find(options.EmailColumn); mapping.EmailCol < 0 {
+			return mapping, fmt.Errorf("%w: email column %q not found", errBadImport, options.EmailColumn)
+		}
+	case mapping.Header:
+		for i, cell := range records[0] {
+			if emailHeaders[strings.ToLower(strings.TrimSpace(cell))] {
+				mapping.EmailCol = i
+				break
+			}
+		}
+	}
+	if mapping.EmailCol < 0 {
+		mapping.EmailCol = sniffEmailColumn(rows)
+	}
+	if mapping.EmailCol < 0 {
+		if len(options.Columns) > 0 {
+			return mapping, fmt.Errorf("%w: no email column found, set email_column", errBadImport)
+		}
+		mapping.EmailCol = 0
+	}
+
+	mapping.Fields = make([]string, width)
+	if len(options.Columns) == 0 {
+		if mapping.Header {
+			for j, cell := range records[0] {
+				if j != mapping.EmailCol {
+					mapping.Fields[j] = mergeFieldName(cell)
+				}
+			}
+		}
+		return mapping, nil
+	}
+
+	columns := make([]string, 0, len(options.Columns))
+	for column := range options.Columns {
+		columns = append(columns, column)
+	}
+	sort.Strings(columns)
+	for _, column := range columns {
+		j := find(column)
+		if j < 0 {
+			return mapping, fmt.Errorf("%w: column %q not found", errBadImport, column)
+		}
+		field := mergeFieldName(options.Columns[column])
+		if field == "" {
+			return mapping, fmt.Errorf("%w: invalid field name %q for column %q", errBadImport, options.Columns[column], column)
+		}
+		if j != mapping.EmailCol {
+			mapping.Fields[j] = field
+		}
+	}
+	return mapping, nil
+}
+
+// looksLikeHeader guesses whether the first row names the columns.
+func looksLikeHeader(records [][]string) bool {
+	for _, cell := range records[0] {
+		cell = strings.TrimSpace(cell)
+		if isValidEmail(normalizeEmail(cell)) {
+			return false
+		}
+		if emailHeaders[strings.ToLower(cell)] {
+			return true
+		}
+	}
+	for _, record := range records[1:min(len(records), sniffSample+1)] {
+		for _, cell := range record {
+			if isValidEmail(normalizeEmail(cell)) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// sniffEmailColumn returns the column holding the most addresses in the
+// first rows, or -1 when there are none.
+func sniffEmailColumn(rows [][]string) int {
+	counts := make(map[int]int)
+	for _, record := range rows[:min(len(rows), sniffSample)] {
+		for i, cell := range record {
+			if isValidEmail(normalizeEmail(cell)) {
+				counts[i]++
+			}
+		}
+	}
+	best := -1
+	for i, count := range counts {
+		if best < 0 || count > counts[best] || (count == counts[best] && i < best) {
+			best = i
+		}
+	}
+	return best
+}
+
+// importRow is one row of a recipient list mapped onto a recipient. Row
+// counts the rows of the list from 1, header included.
+type importRow struct {
+	Row       int
+	Recipient importedRecipient
+}
+
+// mapRows applies the mapping to the rows below the header, leaving out
+// blank rows. Rows without an address are kept with an empty Email.
+func mapRows(records [][]string, mapping columnMapping) []importRow {
+	start := 0
+	if mapping.Header {
+		start = 1
+	}
+
+	var rows []importRow
+	for i := start; i < len(records); i++ {
+		record := records[i]
+		if strings.TrimSpace(strings.Join(record, "")) == "" {
+			continue
+		}
+
+		recipient := importedRecipient{}
+		if mapping.EmailCol < len(record) {
+			recipient.Email = strings.TrimSpace(record[mapping.EmailCol])
+		}
+		for j, value := range record {
+			if j < len(mapping.Fields) && mapping.Fields[j] != "" && strings.TrimSpace(value) != "" {
+				if recipient.Attributes == nil {
+					recipient.Attributes = make(map[string]string)
+				}
+				recipient.Attributes[mapping.Fields[j]] = strings.TrimSpace(value)
+			}
+		}
+		rows = append(rows, importRow{Row: i + 1, Recipient: recipient})
+	}
+	return rows
+}
+
+// mergeFieldName turns a column header such as "first_name" or "First Name"
+// into the field name used in templates ("FirstName").
+func mergeFieldName(header string) string {
+	parts := strings.FieldsFunc(strings.ToLower(header), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+
+	var name strings.Builder
+	for _, part := range parts {
+		runes := []rune(part)
+		runes[0] = unicode.ToUpper(runes[0])
+		name.WriteString(string(runes))
+	}
+	return name.String()
+}
+
+// mergeTemplate renders a group's subject and message for one recipient.
+// Placeholders use Go template syntax: {{.FirstName}}, with fallbacks
+// written as {{or .FirstName "there"}} or {{default "there" .FirstName}}.
+// Unknown fields render as empty strings. Without templates (a group with
+// PlainMessage) the plain subject and message are sent unchanged.
+type mergeTemplate struct {
+	subject *template.Template
+	message *template.Template
+
+	plainSubject string
+	plainMessage string
+}
+
+var mergeFuncs = template.FuncMap{
+	"default": func(fallback, value string) string {
+		if strings.TrimSpace(value) == "" {
+			return fallback
+		}
+		return value
+	},
+}
+
+func parseMergeTemplate(subject, message string) (*mergeTemplate, error) {
+	subjectTmpl, err := template.New("subject").Funcs(mergeFuncs).Option("missingkey=zero").Parse(subject)
+	if err != nil {
+		return nil, fmt.Errorf("invalid merge field in subject: %v (set plain_message to send it unchanged)", err)
+	}
+	messageTmpl, err := template.New("message").Funcs(mergeFuncs).Option("missingkey=zero").Parse(message)
+	if err != nil {
+		return nil, fmt.Errorf("invalid merge field in message: %v (set plain_message to send it unchanged)", err)
+	}
+	return &mergeTemplate{subject: subjectTmpl, message: messageTmpl}, nil
+}
+
+// groupTemplate returns the template a group is mailed with.
+func groupTemplate(group models.Group) (*mergeTemplate, error) {
+	if group.PlainMessage {
+		return &mergeTemplate{plainSubject: group.Subject, plainMessage: group.Message}, nil
+	}
+	return parseMergeTemplate(group.Subject, group.Message)
+}
+
+// render fills in the placeholders. Values are HTML-escaped in the message
+// and stripped of line breaks in the subject.
+func (m *mergeTemplate) render(email string, attributes map[string]string) (string, string, error) {
+	if m.subject == nil {
+		return strings.NewReplacer("\r", " ", "\n", " ").Replace(m.plainSubject), m.plainMessage, nil
+	}
+
+	plain := map[string]string{"Email": email}
+	escaped := map[string]string{"Email": html.EscapeString(email)}
+	for key, value := range attributes {
+		plain[key] = value
+		escaped[key] = html.EscapeString(value)
+	}
+
+	var subject, message bytes.Buffer
+	if err := m.subject.Execute(&subject, plain); err != nil {
+		return "", "", err
+	}
+	if err := m.message.Execute(&message, escaped); err != nil {
+		return "", "", err
+	}
+
+	cleanSubject := strings.NewReplacer("\r", " ", "\n", " ").Replace(subject.String())
+	return cleanSubject, message.String(), nil
+}
@@ -0,0 +1,41 @@
+package handlers
+
+import (
+	"strings"
+
+	"golang.org/x/net/idna"
+)
+
+// normalizeEmail puts an imported address into the form it is stored in:
+// trimmed, with the domain lower-cased and converted to punycode
+// (user@bücher.de -> user@xn--bcher-kva.de). The local part is kept as
+// given since servers may treat it case-sensitively.
+func normalizeEmail(email string) string {
+	email = strings.TrimSpace(email)
+	at := strings.LastIndex(email, "@")
+	if at < 0 {
+		return email
+	}
+
+	local, domain := email[:at], strings.ToLower(strings.TrimSuffix(email[at+1:], "."))
+	if ascii, err := idna.Lookup.ToASCII(domain); err == nil {
+		domain = ascii
+	}
+	return local + "@" + domain
+}
+
+// recipientKey is what two addresses of a group are compared by when
+// deduplicating. Addresses differing only in case are the same recipient,
+// and with mergePlus so are plus-addressing variants (jane+news@x.com and
+// jane@x.com).
+func recipientKey(email string, mergePlus bool) string {
+	key := strings.ToLower(normalizeEmail(email))
+	if !mergePlus {
+		return key
+	}
+	at := strings.LastIndex(key, "@")
+	if plus := strings.Index(key, "+"); plus > 0 && plus < at {
+		key = key[:plus] + key[at:]
+	}
+	return key
+}
@@ -0,0 +1,262 @@
+package handlers
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"log"
+	"net/http"
+	"net/mail"
+	"strings"
+
+	"github.com/karan-singh-17/Quick-Mail/database"
+	"github.com/karan-singh-17/Quick-Mail/mailer"
+	"github.com/karan-singh-17/Quick-Mail/models"
+)
+
+// testSendLimit caps how many addresses one test send may go to.
+const testSendLimit = 5
+
+// testSendAllowlist holds addresses any user may send tests to besides
+// their own, e.g. a shared QA inbox.
+var testSendAllowlist = strings.Split(strings.ToLower(envOr("testSendAllowlist", "")), ",")
+
+// previewRecipient picks the recipient whose merge fields fill the preview:
+// the requested member, else the first one, else the current user.
+func previewRecipient(grp models.Group, email string, curr_user models.User) (string, map[string]string, bool) {
+	var recipient models.Recipient
+	if strings.TrimSpace(email) != "" {
+		ids, err := matchRecipients(database.DB, grp, []string{email})
+		if err != nil || len(ids) == 0 {
+			return "", nil, false
+		}
+		err = database.DB.Where("id = ?", ids[0]).First(&recipient).Error
+		return recipient.Email, recipient.Attributes, err == nil
+	}
+
+	if err := database.DB.Where("group_id = ? AND status = ?", grp.Group_ID, models.RecipientActive).Order("id").First(&recipient).Error; err != nil {
+		return curr_user.Email, nil, true
+	}
+	return recipient.Email, recipient.Attributes, true
+}
+
+// inlineImages replaces cid: references with data URIs so a browser can
+// show the preview's inline images.
+func inlineImages(htmlText string, attachments []mailer.Attachment) string {
+	for _, attachment := range attachments {
+		if !attachment.Inline || attachment.ContentID == "" {
+			continue
+		}
+		dataURI := "data:" + attachment.ContentType + ";base64," + base64.StdEncoding.EncodeToString(attachment.Data)
+		htmlText = strings.ReplaceAll(htmlText, "cid:"+attachment.ContentID, dataURI)
+	}
+	return htmlText
+}
+
+// Preview Group
+// @Summary preview the mail of a group
+// @Description renders the mail exactly as a recipient would get it: merged with that recipient's fields, wrapped in the mail template, with all headers. Pick the recipient with email (defaults to the first one). format=json (default) returns the parts and headers, format=html the HTML body and format=eml the complete message. Make sure you are logged in and are the owner of the group.
+// @Tags Groups
+// @Produce json,html
+// @Param id path string true "Group ID"
+// @Param email query string false "Recipient to render the mail for"
+// @Param format query string false "json, html or eml"
+// @Success 200 {object} map[string]interface{} "Rendered mail"
+// @Failure 400 {object} string "Invalid group message"
+// @Failure 401 {object} string "Unauthorized"
+// @Failure 404 {object} string "Recipient not in group"
+// @Router /api/group/{id}/preview [get]
+// @security jwt_token
+func PreviewGroup(w http.ResponseWriter, r *http.Request) {
+	grp, curr_user, ok := ownedGroup(w, r)
+	if !ok {
+		return
+	}
+
+	recipient, attributes, found := previewRecipient(grp, r.URL.Query().Get("email"), curr_user)
+	if !found {
+		http.Error(w, "Recipient not in group", http.StatusNotFound)
+		return
+	}
+
+	composer, err := newGroupComposer(grp)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	email, err := composer.compose(recipient, attributes, recipient)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	message, err := email.Bytes()
+	if err != nil {
+		http.Error(w, "Error building message", http.StatusInternalServerError)
+		return
+	}
+
+	switch r.URL.Query().Get("format") {
+	case "html":
+		// The message is user content served from the API's origin, so keep
+		// any scripts in it from running.
+		w.Header().Set("Content-Security-Policy", "sandbox")
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		w.Write([]byte(inlineImages(email.HTML, email.Attachments)))
+		return
+	case "eml":
+		w.Header().Set("Content-Type", "message/rfc822")
+		w.Header().Set("Content-Disposition", `attachment; filename="`+grp.Group_ID+`-preview.eml"`)
+		w.Write(message)
+		return
+	}
+
+	parsed, err := mail.ReadMessage(bytes.NewReader(message))
+	if err != nil {
+		http.Error(w, "Error building message", http.StatusInternalServerError)
+		return
+	}
+	headers := make(map[string]string, len(parsed.Header))
+	for key, values := range parsed.Header {
+		headers[key] = strings.Join(values, ", ")
+	}
+
+	attachments := make([]string, 0, len(email.Attachments))
+	for _, attachment := range email.Attachments {
+		attachments = append(attachments, attachment.Filename)
+	}
+
+	response := map[string]interface{}{
+		"status":      http.StatusOK,
+		"recipient":   recipient,
+		"subject":     email.Subject,
+		"headers":     headers,
+		"html":        email.HTML,
+		"text":        mailer.HTMLToText(email.HTML),
+		"attachments": attachments,
+		"size":        len(message),
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(response)
+}
+
+type TestSendData struct {
+	To    []string `json:"to,omitempty"`
+	Email string   `json:"email,omitempty"`
+}
+
+// testSendAllowed lists the addresses the user may send tests to: their
+// own, their verified sender identities and the server-wide allowlist.
+func testSendAllowed(curr_user models.User) (map[string]bool, error) {
+	allowed := map[string]bool{strings.ToLower(curr_user.Email): true}
+	for _, email := range testSendAllowlist {
+		if email = strings.TrimSpace(email); email != "" {
+			allowed[email] = true
+		}
+	}
+
+	var senders []models.SenderIdentity
+	if err := database.DB.Select("email").Where("owner_id = ? AND verified = ?", curr_user.Id, true).Find(&senders).Error; err != nil {
+		return nil, err
+	}
+	for _, sender := range senders {
+		allowed[strings.ToLower(sender.Email)] = true
+	}
+	return allowed, nil
+}
+
+// Test Send Group
+// @Summary send a test mail of a group
+// @Description sends the group mail, rendered for one of its recipients (email, default the first), to yourself. "to" may list up to 5 addresses, each of which must be your own, one of your verified senders or on the server's test allowlist. The subject is prefixed with [Test] and nothing is recorded as a campaign. Make sure you are logged in and are the owner of the group.
+// @Tags Groups
+// @Accept json
+// @Produce json
+// @Param id path string true "Group ID"
+// @Param test body TestSendData false "Test addresses and recipient to render for"
+// @Success 200 {object} map[string]interface{} "sent and failed addresses"
+// @Failure 400 {object} string "Invalid Input"
+// @Failure 401 {object} string "Unauthorized"
+// @Failure 403 {object} string "Address not allowed for test sends"
+// @Failure 404 {object} string "Recipient not in group"
+// @Router /api/group/{id}/test-send [post]
+// @security jwt_token
+func TestSendGroup(w http.ResponseWriter, r *http.Request) {
+	grp, curr_user, ok := ownedGroup(w, r)
+	if !ok {
+		return
+	}
+
+	var data TestSendData
+	if r.ContentLength != 0 {
+		if err := json.NewDecoder(r.Body).Decode(&data); err != nil {
+			http.Error(w, "Invalid Input", http.StatusBadRequest)
+			return
+		}
+	}
+	if len(data.To) == 0 {
+		data.To = []string{curr_user.Email}
+	}
+	if len(data.To) > testSendLimit {
+		http.Error(w, "A test can be sent to at most 5 addresses", http.StatusBadRequest)
+		return
+	}
+
+	allowed, err := testSendAllowed(curr_user)
+	if err != nil {
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+	for i, to := range data.To {
+		data.To[i] = strings.TrimSpace(to)
+		if !allowed[strings.ToLower(data.To[i])] {
+			http.Error(w, "Address not allowed for test sends: "+data.To[i], http.StatusForbidden)
+			return
+		}
+	}
+
+	recipient, attributes, found := previewRecipient(grp, data.Email, curr_user)
+	if !found {
+		http.Error(w, "Recipient not in group", http.StatusNotFound)
+		return
+	}
+
+	composer, err := newGroupComposer(grp)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	throttled := sendThrottle.Wrap(composer.sender.Transport)
+	var sent []string
+	failed := make(map[string]string)
+	for _, to := range data.To {
+		email, err := composer.compose(recipient, attributes, to)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		email.To = []string{to}
+		email.Subject = "[Test] " + email.Subject
+
+		message, err := email.Bytes()
+		if err == nil {
+			err = throttled.Send(composer.sender.From.Address, []string{to}, message)
+		}
+		if err != nil {
+			log.Printf("Error sending test mail to %s: %v", to, err)
+			failed[to] = deliveryError(err)
+			continue
+		}
+		sent = append(sent, to)
+	}
+
+	response := map[string]interface{}{
+		"status":    http.StatusOK,
+		"recipient": recipient,
+		"sent":      sent,
+		"failed":    failed,
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(response)
+}
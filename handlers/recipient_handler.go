@@ -0,0 +1,388 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/karan-singh-17/Quick-Mail/database"
+	"github.com/karan-singh-17/Quick-Mail/models"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// saveRecipients adds recipients to a group. Addresses are normalized first
+// (see normalizeEmail); blank ones are skipped and ones matching another
+// address of the import or an existing member (see recipientKey) are merged
+// into it. It returns how many rows were inserted and how many were merged.
+func saveRecipients(tx *gorm.DB, group models.Group, recipients []importedRecipient) (int64, int64, error) {
+	emails := make([]string, len(recipients))
+	for i, recipient := range recipients {
+		emails[i] = recipient.Email
+	}
+	seen, err := existingKeys(tx, group, emails)
+	if err != nil {
+		return 0, 0, err
+	}
+
+	var rows []models.Recipient
+	var merged int64
+	for _, recipient := range recipients {
+		email := normalizeEmail(recipient.Email)
+		if email == "" {
+			continue
+		}
+		key := recipientKey(email, group.MergePlusAddresses)
+		if seen[key] {
+			merged++
+			continue
+		}
+		seen[key] = true
+		rows = append(rows, models.Recipient{
+			Group_ID:   group.Group_ID,
+			Email:      email,
+			EmailKey:   recipientKey(email, false),
+			PlusKey:    recipientKey(email, true),
+			Attributes: recipient.Attributes,
+			Status:     models.RecipientActive,
+			Source:     recipient.Source,
+			AddedAt:    time.Now(),
+		})
+	}
+	if len(rows) == 0 {
+		return 0, merged, nil
+	}
+
+	res := tx.Clauses(clause.OnConflict{DoNothing: true}).CreateInBatches(rows, 500)
+	return res.RowsAffected, merged + int64(len(rows)) - res.RowsAffected, res.Error
+}
+
+// recipientKeyColumn is the column holding the recipientKey of members as
+// the group compares them.
+func recipientKeyColumn(group models.Group) string {
+	if group.MergePlusAddresses {
+		return "plus_key"
+	}
+	return "email_key"
+}
+
+// lookupKeys returns the distinct recipientKeys of emails, skipping blank
+// addresses.
+func lookupKeys(group models.Group, emails []string) []string {
+	seen := make(map[string]bool, len(emails))
+	var keys []string
+	for _, email := range emails {
+		if email = normalizeEmail(email); email == "" {
+			continue
+		}
+		key := recipientKey(email, group.MergePlusAddresses)
+		if !seen[key] {
+			seen[key] = true
+			keys = append(keys, key)
+		}
+	}
+	return keys
+}
+
+// existingKeys returns the recipientKeys of emails that already belong to a
+// member of the group.
+func existingKeys(tx *gorm.DB, group models.Group, emails []string) (map[string]bool, error) {
+	if err := fillRecipientKeys(tx, group.Group_ID); err != nil {
+		return nil, err
+	}
+
+	column := recipientKeyColumn(group)
+	keys := lookupKeys(group, emails)
+	existing := make(map[string]bool)
+	for start := 0; start < len(keys); start += 1000 {
+		var found []string
+		end := min(start+1000, len(keys))
+		if err := tx.Model(&models.Recipient{}).Where("group_id = ? AND "+column+" IN ?", group.Group_ID, keys[start:end]).
+			Pluck(column, &found).Error; err != nil {
+			return nil, err
+		}
+		for _, key := range found {
+			existing[key] = true
+		}
+	}
+	return existing, nil
+}
+
+// matchRecipients returns the IDs of the members of a group that emails
+// refer to, compared the way imports deduplicate (see recipientKey), so an
+// address matches however its domain or case was written.
+func matchRecipients(tx *gorm.DB, group models.Group, emails []string) ([]uint, error) {
+	if err := fillRecipientKeys(tx, group.Group_ID); err != nil {
+		return nil, err
+	}
+
+	column := recipientKeyColumn(group)
+	keys := lookupKeys(group, emails)
+	var ids []uint
+	for start := 0; start < len(keys); start += 1000 {
+		var found []uint
+		end := min(start+1000, len(keys))
+		if err := tx.Model(&models.Recipient{}).Where("group_id = ? AND "+column+" IN ?", group.Group_ID, keys[start:end]).
+			Pluck("id", &found).Error; err != nil {
+			return nil, err
+		}
+		ids = append(ids, found...)
+	}
+	return ids, nil
+}
+
+// fillRecipientKeys sets the key columns of members stored before they
+// existed. Once a group has been filled this finds nothing to do.
+func fillRecipientKeys(tx *gorm.DB, groupID string) error {
+	var rows []models.Recipient
+	if err := tx.Select("id, email").Where("group_id = ? AND (email_key = '' OR email_key IS NULL)", groupID).Find(&rows).Error; err != nil {
+		return err
+	}
+	for _, row := range rows {
+		keys := map[string]interface{}{"email_key": recipientKey(row.Email, false), "plus_key": recipientKey(row.Email, true)}
+		if err := tx.Model(&models.Recipient{}).Where("id = ?", row.ID).Updates(keys).Error; err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// activeRecipients returns the members of a group that should be mailed.
+func activeRecipients(groupID string) ([]models.Recipient, error) {
+	var recipients []models.Recipient
+	err := database.DB.Where("group_id = ? AND status = ?", groupID, models.RecipientActive).Order("id").Find(&recipients).Error
+	return recipients, err
+}
+
+// fillRecipientCounts sets RecipientCount on each of the groups.
+func fillRecipientCounts(groups []models.Group) error {
+	if len(groups) == 0 {
+		return nil
+	}
+
+	ids := make([]string, len(groups))
+	for i, group := range groups {
+		ids[i] = group.Group_ID
+	}
+
+	var counts []struct {
+		Group_ID string
+		Count    int64
+	}
+	if err := database.DB.Model(&models.Recipient{}).Select("group_id, count(*) as count").
+		Where("group_id IN ?", ids).Group("group_id").Scan(&counts).Error; err != nil {
+		return err
+	}
+
+	byGroup := make(map[string]int64, len(counts))
+	for _, c := range counts {
+		byGroup[c.Group_ID] = c.Count
+	}
+	for i := range groups {
+		groups[i].RecipientCount = byGroup[groups[i].Group_ID]
+	}
+	return nil
+}
+
+// recipientInput accepts either a bare address or an object carrying merge
+// fields: "a@b.com" or {"email": "a@b.com", "attributes": {"FirstName": "A"}}.
+type recipientInput struct {
+	Email      string            `json:"email"`
+	Attributes map[string]string `json:"attributes,omitempty"`
+}
+
+func (in *recipientInput) UnmarshalJSON(b []byte) error {
+	var email string
+	if err := json.Unmarshal(b, &email); err == nil {
+		in.Email = email
+		return nil
+	}
+
+	type plain recipientInput
+	return json.Unmarshal(b, (*plain)(in))
+}
+
+type RecipientsData struct {
+	Recipients []recipientInput `json:"recipients"`
+}
+
+// List Recipients
+// @Summary list the recipients of a group
+// @Description returns one page of the recipients of a group, optionally filtered by a search on the email address. Make sure you are logged in and are the owner of the group.
+// @Tags Recipients
+// @Produce json
+// @Param id path string true "Group ID"
+// @Param page query int false "Page number (default 1)"
+// @Param page_size query int false "Recipients per page (default 50, max 500)"
+// @Param search query string false "Only addresses containing this text"
+// @Success 200 {object} map[string]interface{} "Page of recipients"
+// @Failure 401 {object} string "Unauthorized"
+// @Failure 404 {object} string "Group Not Found"
+// @Router /api/group/{id}/recipients [get]
+// @security jwt_token
+func ListRecipients(w http.ResponseWriter, r *http.Request) {
+	grp, _, ok := ownedGroup(w, r)
+	if !ok {
+		return
+	}
+
+	page, _ := strconv.Atoi(r.URL.Query().Get("page"))
+	if page < 1 {
+		page = 1
+	}
+	pageSize, _ := strconv.Atoi(r.URL.Query().Get("page_size"))
+	if pageSize < 1 {
+		pageSize = 50
+	}
+	if pageSize > 500 {
+		pageSize = 500
+	}
+
+	query := database.DB.Model(&models.Recipient{}).Where("group_id = ?", grp.Group_ID)
+	if search := strings.TrimSpace(r.URL.Query().Get("search")); search != "" {
+		escaped := strings.NewReplacer(`\`, `\\`, "%", `\%`, "_", `\_`).Replace(search)
+		query = query.Where("email LIKE ?", "%"+escaped+"%")
+	}
+
+	var total int64
+	if err := query.Count(&total).Error; err != nil {
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+
+	var recipients []models.Recipient
+	if err := query.Order("id").Offset((page - 1) * pageSize).Limit(pageSize).Find(&recipients).Error; err != nil {
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+
+	response := map[string]interface{}{
+		"status":     http.StatusOK,
+		"recipients": recipients,
+		"page":       page,
+		"page_size":  pageSize,
+		"total":      total,
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(response)
+}
+
+// Add Recipients
+// @Summary add recipients to a group
+// @Description adds one or more recipients to a group. Each entry is either an email address or an object with "email" and "attributes" (merge fields). Addresses are normalized (trimmed, domain lower-cased and punycoded) and ones already in the group, or repeated in the request, are merged; with the group's merge_plus_addresses option so are plus-addressing variants. Make sure you are logged in and are the owner of the group.
+// @Tags Recipients
+// @Accept json
+// @Produce json
+// @Param id path string true "Group ID"
+// @Param Recipients body RecipientsData true "Recipients"
+// @Success 200 {object} map[string]interface{} "added, merged and invalid addresses"
+// @Failure 400 {object} string "Invalid Input"
+// @Failure 401 {object} string "Unauthorized"
+// @Failure 404 {object} string "Group Not Found"
+// @Router /api/group/{id}/recipients [post]
+// @security jwt_token
+func AddRecipients(w http.ResponseWriter, r *http.Request) {
+	grp, _, ok := ownedGroup(w, r)
+	if !ok {
+		return
+	}
+
+	var data RecipientsData
+	if err := json.NewDecoder(r.Body).Decode(&data); err != nil || len(data.Recipients) == 0 {
+		http.Error(w, "Invalid Input", http.StatusBadRequest)
+		return
+	}
+
+	var valid []importedRecipient
+	invalid := []string{}
+	for _, in := range data.Recipients {
+		email := normalizeEmail(in.Email)
+		if !isValidEmail(email) {
+			invalid = append(invalid, in.Email)
+			continue
+		}
+		valid = append(valid, importedRecipient{Email: email, Attributes: in.Attributes})
+	}
+
+	added, merged, err := saveRecipients(database.DB, grp, valid)
+	if err != nil {
+		http.Error(w, "Error adding recipients", http.StatusInternalServerError)
+		return
+	}
+
+	response := map[string]interface{}{
+		"status":  http.StatusOK,
+		"added":   added,
+		"merged":  merged,
+		"invalid": invalid,
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(response)
+}
+
+// Remove Recipients
+// @Summary remove recipients from a group
+// @Description removes the listed addresses from a group, or the single address given in the path. Make sure you are logged in and are the owner of the group.
+// @Tags Recipients
+// @Accept json
+// @Produce json
+// @Param id path string true "Group ID"
+// @Param Recipients body RecipientsData false "Recipients"
+// @Success 200 {object} map[string]interface{} "removed"
+// @Failure 400 {object} string "Invalid Input"
+// @Failure 401 {object} string "Unauthorized"
+// @Failure 404 {object} string "Group Not Found"
+// @Router /api/group/{id}/recipients [delete]
+// @Router /api/group/{id}/recipients/{email} [delete]
+// @security jwt_token
+func RemoveRecipients(w http.ResponseWriter, r *http.Request) {
+	grp, _, ok := ownedGroup(w, r)
+	if !ok {
+		return
+	}
+
+	var emails []string
+	if email := r.PathValue("email"); email != "" {
+		emails = append(emails, email)
+	} else {
+		var data RecipientsData
+		if err := json.NewDecoder(r.Body).Decode(&data); err != nil {
+			http.Error(w, "Invalid Input", http.StatusBadRequest)
+			return
+		}
+		for _, in := range data.Recipients {
+			emails = append(emails, strings.TrimSpace(in.Email))
+		}
+	}
+	if len(emails) == 0 {
+		http.Error(w, "Invalid Input", http.StatusBadRequest)
+		return
+	}
+
+	ids, err := matchRecipients(database.DB, grp, emails)
+	if err != nil {
+		http.Error(w, "Error removing recipients", http.StatusInternalServerError)
+		return
+	}
+	var removed int64
+	if len(ids) > 0 {
+		res := database.DB.Where("group_id = ? AND id IN ?", grp.Group_ID, ids).Delete(&models.Recipient{})
+		if res.Error != nil {
+			http.Error(w, "Error removing recipients", http.StatusInternalServerError)
+			return
+		}
+		removed = res.RowsAffected
+	}
+
+	response := map[string]interface{}{
+		"status":  http.StatusOK,
+		"removed": removed,
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(response)
+}
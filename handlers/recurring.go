@@ -0,0 +1,166 @@
+package handlers
+
+import (
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/karan-singh-17/Quick-Mail/cron"
+	"github.com/karan-singh-17/Quick-Mail/database"
+	"github.com/karan-singh-17/Quick-Mail/models"
+	"gorm.io/gorm"
+)
+
+const recurringPollInterval = 30 * time.Second
+
+// StartRecurringScheduler launches the loop that turns due occurrences of
+// cron groups into campaigns.
+func StartRecurringScheduler() {
+	go func() {
+		ticker := time.NewTicker(recurringPollInterval)
+		defer ticker.Stop()
+
+		for {
+			enqueueDueGroups()
+			<-ticker.C
+		}
+	}()
+}
+
+func enqueueDueGroups() {
+	var groups []models.Group
+	if err := database.DB.Where("cron <> '' AND next_run_at <= ?", time.Now()).Find(&groups).Error; err != nil {
+		log.Println("Error loading recurring groups:", err)
+		return
+	}
+
+	for _, group := range groups {
+		occurrence := *group.NextRunAt
+		next, err := nextCronRun(group.Cron, group.CronTimeZone, group.CronEndAt, time.Now())
+		if err != nil {
+			log.Printf("Invalid schedule on group %s: %v", group.Group_ID, err)
+			continue
+		}
+
+		// Advancing next_run_at only if nobody else did makes sure every
+		// occurrence produces exactly one campaign, even when several
+		// servers share the database. Occurrences missed while the server
+		// was down collapse into this single run.
+		var campaign models.Campaign
+		err = database.DB.Transaction(func(tx *gorm.DB) error {
+			res := tx.Model(&models.Group{}).Where("group_id = ? AND next_run_at = ?", group.Group_ID, occurrence).Update("next_run_at", next)
+			if res.Error != nil || res.RowsAffected == 0 {
+				return res.Error
+			}
+
+			tokenid, err := generateToken()
+			if err != nil {
+				return err
+			}
+			campaign = models.Campaign{
+				Campaign_ID: "c-" + tokenid,
+				Group_ID:    group.Group_ID,
+				Owner_ID:    group.Owner_ID,
+				Status:      models.CampaignQueued,
+				Trigger:     models.TriggerCron,
+				ScheduledAt: &occurrence,
+				TimeZone:    group.CronTimeZone,
+				CreatedAt:   time.Now(),
+			}
+			return tx.Create(&campaign).Error
+		})
+		if err != nil {
+			log.Printf("Error enqueueing recurring run of group %s: %v", group.Group_ID, err)
+			continue
+		}
+		if campaign.Campaign_ID != "" {
+			log.Printf("Queued recurring campaign %s for group %s", campaign.Campaign_ID, group.Group_ID)
+			select {
+			case campaignWake <- struct{}{}:
+			default:
+			}
+		}
+	}
+}
+
+// nextCronRun returns the first occurrence of expr after the given time, or
+// nil once the schedule has passed endAt.
+func nextCronRun(expr, timeZone string, endAt *time.Time, after time.Time) (*time.Time, error) {
+	schedule, err := cron.Parse(expr)
+	if err != nil {
+		return nil, err
+	}
+	loc, err := time.LoadLocation(timeZone)
+	if err != nil {
+		return nil, fmt.Errorf("unknown time zone %q", timeZone)
+	}
+
+	next := schedule.Next(after.In(loc))
+	if next.IsZero() || (endAt != nil && next.After(*endAt)) {
+		return nil, nil
+	}
+	next = next.UTC()
+	return &next, nil
+}
+
+// editCronSchedule applies the cron, cron_time_zone and cron_end_at of an
+// edit, keeping the group's stored values for the ones that aren't given.
+// An empty cron_end_at removes the end.
+func editCronSchedule(grp *models.Group, data map[string]string) error {
+	expr, timeZone := grp.Cron, grp.CronTimeZone
+	if value, ok := data["cron"]; ok {
+		expr = value
+	}
+	if value, ok := data["cron_time_zone"]; ok {
+		timeZone = value
+	}
+	if strings.TrimSpace(expr) == "" && strings.TrimSpace(data["cron_time_zone"]+data["cron_end_at"]) != "" {
+		return fmt.Errorf("cron_time_zone and cron_end_at need a cron schedule")
+	}
+
+	endAt, ok := data["cron_end_at"]
+	if !ok && grp.CronEndAt != nil {
+		// The stored end is an instant; it is given in the (possibly new)
+		// time zone so changing the zone doesn't move it.
+		loc, err := time.LoadLocation(strings.TrimSpace(timeZone))
+		if err != nil {
+			return fmt.Errorf("unknown cron_time_zone %q", timeZone)
+		}
+		endAt = grp.CronEndAt.In(loc).Format("2006-01-02T15:04:05")
+	}
+	return applyCronSchedule(grp, expr, timeZone, endAt)
+}
+
+// applyCronSchedule validates a recurring schedule and stores it on the
+// group. An empty expression removes the schedule.
+func applyCronSchedule(grp *models.Group, expr, timeZone, endAt string) error {
+	expr = strings.TrimSpace(expr)
+	if expr == "" {
+		grp.Cron, grp.CronTimeZone, grp.CronEndAt, grp.NextRunAt = "", "", nil, nil
+		return nil
+	}
+	if strings.TrimSpace(timeZone) == "" {
+		return fmt.Errorf("cron_time_zone is required with cron")
+	}
+
+	var end *time.Time
+	if strings.TrimSpace(endAt) != "" {
+		at, err := parseSendAt(endAt, timeZone)
+		if err != nil {
+			return fmt.Errorf("invalid cron_end_at: %v", err)
+		}
+		end = &at
+	}
+
+	next, err := nextCronRun(expr, timeZone, end, time.Now())
+	if err != nil {
+		return err
+	}
+	if next == nil {
+		return fmt.Errorf("cron schedule has no occurrence before its end")
+	}
+
+	grp.Cron, grp.CronTimeZone, grp.CronEndAt, grp.NextRunAt = expr, timeZone, end, next
+	return nil
+}
@@ -0,0 +1,393 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"html"
+	"html/template"
+	"log"
+	"net/http"
+	"net/mail"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/karan-singh-17/Quick-Mail/database"
+	"github.com/karan-singh-17/Quick-Mail/fetcher"
+	"github.com/karan-singh-17/Quick-Mail/mailer"
+	"github.com/karan-singh-17/Quick-Mail/models"
+	"github.com/karan-singh-17/Quick-Mail/vault"
+	"gorm.io/gorm"
+)
+
+// senderVerifyTTL is how long the confirmation link of a new sender works.
+const senderVerifyTTL = 24 * time.Hour
+
+var senderVerifiedTemplate = template.Must(template.ParseFiles("templates/sender_verified.html"))
+
+// senderTransports holds one transport per identity with its own SMTP
+// server so its connections are pooled across campaigns.
+var senderTransports = struct {
+	sync.Mutex
+	data map[string]mailer.Transport
+}{data: make(map[string]mailer.Transport)}
+
+// mailSender is who a group's mails go out as.
+type mailSender struct {
+	From      mail.Address
+	ReplyTo   string
+	Transport mailer.Transport
+	// OwnServer is set when Transport is the identity's own SMTP server,
+	// whose bounces are only trusted for the identity's owner.
+	OwnServer bool
+}
+
+// groupSender resolves the sender of a group: its verified identity, or the
+// server's own address when it has none.
+func groupSender(group models.Group) (mailSender, error) {
+	if group.Sender_ID == "" {
+		return mailSender{From: mail.Address{Address: from}, Transport: transport}, nil
+	}
+
+	var identity models.SenderIdentity
+	if err := database.DB.Where("sender_id = ? AND owner_id = ?", group.Sender_ID, group.Owner_ID).First(&identity).Error; err != nil {
+		return mailSender{}, fmt.Errorf("sender identity %s not found", group.Sender_ID)
+	}
+	if !identity.Verified {
+		return mailSender{}, fmt.Errorf("sender %s is not verified", identity.Email)
+	}
+
+	sender := mailSender{
+		From:      mail.Address{Name: identity.DisplayName, Address: identity.Email},
+		ReplyTo:   identity.ReplyTo,
+		Transport: transport,
+	}
+	if identity.SMTPHost != "" {
+		sender.Transport = senderTransport(identity)
+		sender.OwnServer = true
+	}
+	return sender, nil
+}
+
+func senderTransport(identity models.SenderIdentity) mailer.Transport {
+	senderTransports.Lock()
+	defer senderTransports.Unlock()
+
+	if t, ok := senderTransports.data[identity.Sender_ID]; ok {
+		return t
+	}
+	username := identity.SMTPUsername
+	if username == "" {
+		username = identity.Email
+	}
+	// The server is the user's choice, so it is dialed like a fetched link
+	// and can't point at the server's own network.
+	t := mailer.NewSealedSMTP(identity.SMTPHost, identity.SMTPPort, username, identity.SMTPPassword, fetcher.DialContext)
+	senderTransports.data[identity.Sender_ID] = t
+	return t
+}
+
+// dropSenderTransport closes the cached transport of an identity.
+func dropSenderTransport(senderID string) {
+	senderTransports.Lock()
+	t, ok := senderTransports.data[senderID]
+	delete(senderTransports.data, senderID)
+	senderTransports.Unlock()
+
+	if closer, ok2 := t.(interface{ Close() }); ok && ok2 {
+		closer.Close()
+	}
+}
+
+// checkSender makes sure a group may be sent from the identity.
+func checkSender(ownerID, senderID string) error {
+	if senderID == "" {
+		return nil
+	}
+	var identity models.SenderIdentity
+	if err := database.DB.Where("sender_id = ? AND owner_id = ?", senderID, ownerID).First(&identity).Error; err != nil {
+		return fmt.Errorf("sender identity %s not found", senderID)
+	}
+	if !identity.Verified {
+		return fmt.Errorf("sender %s is not verified yet", identity.Email)
+	}
+	return nil
+}
+
+// checkSMTPServer refuses SMTP servers the fetcher wouldn't connect to
+// either, such as internal hosts. Sends to the server are checked again
+// when they dial.
+func checkSMTPServer(host, port string) error {
+	if n, err := strconv.Atoi(port); err != nil || n < 1 || n > 65535 {
+		return fmt.Errorf("invalid smtp_port")
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	err := fetcher.CheckHost(ctx, host)
+	if errors.Is(err, fetcher.ErrBlocked) {
+		return fmt.Errorf("smtp_host must be a public mail server")
+	}
+	if err != nil {
+		return fmt.Errorf("smtp_host can't be resolved")
+	}
+	return nil
+}
+
+func sendSenderVerification(identity models.SenderIdentity, ownerEmail string) error {
+	htmlContent, err := os.ReadFile("templates/verify_sender.html")
+	if err != nil {
+		return fmt.Errorf("error reading HTML template: %v", err)
+	}
+
+	htmlText := strings.NewReplacer(
+		"{{OWNER}}", html.EscapeString(ownerEmail),
+		"{{EMAIL}}", html.EscapeString(identity.Email),
+		"{{LINK}}", baseURL+"/api/senders/verify/"+identity.VerifyToken,
+	).Replace(string(htmlContent))
+
+	message, err := (&mailer.Email{
+		From:    mail.Address{Address: from},
+		To:      []string{identity.Email},
+		Subject: "Verify Your Sender Address",
+		HTML:    htmlText,
+		DKIM:    dkimSigner("", from),
+	}).Bytes()
+	if err != nil {
+		return err
+	}
+
+	return transport.Send(from, []string{identity.Email}, message)
+}
+
+type SenderData struct {
+	DisplayName  string `json:"display_name"`
+	Email        string `json:"email"`
+	ReplyTo      string `json:"reply_to,omitempty"`
+	SMTPHost     string `json:"smtp_host,omitempty"`
+	SMTPPort     string `json:"smtp_port,omitempty"`
+	SMTPUsername string `json:"smtp_username,omitempty"`
+	SMTPPassword string `json:"smtp_password,omitempty"`
+}
+
+// List Senders
+// @Summary list your sender identities
+// @Description returns the addresses the current user can send group mails from, and whether each one has been verified.
+// @Tags Senders
+// @Produce json
+// @Success 200 {object} map[string]interface{} "senders"
+// @Failure 401 {object} string "Unauthorized"
+// @Router /api/senders [get]
+// @security jwt_token
+func ListSenders(w http.ResponseWriter, r *http.Request) {
+	curr_user, err := GetUser(w, r)
+	if err != nil {
+		return
+	}
+
+	var senders []models.SenderIdentity
+	if err := database.DB.Where("owner_id = ?", curr_user.Id).Order("created_at").Find(&senders).Error; err != nil {
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+
+	response := map[string]interface{}{
+		"status":  http.StatusOK,
+		"senders": senders,
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(response)
+}
+
+// Add Sender
+// @Summary register a sender identity
+// @Description registers an address to send group mails from, with an optional display name, reply-to and SMTP server of its own. A confirmation mail is sent to the address; the identity can only be used once the link in it is opened.
+// @Tags Senders
+// @Accept json
+// @Produce json
+// @Param sender body SenderData true "Sender identity"
+// @Success 201 {object} map[string]interface{} "Verification mail sent"
+// @Failure 400 {object} string "Invalid Input"
+// @Failure 401 {object} string "Unauthorized"
+// @Failure 409 {object} string "Sender already registered"
+// @Failure 500 {object} string "Error sending verification email"
+// @Router /api/senders [post]
+// @security jwt_token
+func AddSender(w http.ResponseWriter, r *http.Request) {
+	curr_user, err := GetUser(w, r)
+	if err != nil {
+		return
+	}
+
+	var data SenderData
+	if err := json.NewDecoder(r.Body).Decode(&data); err != nil {
+		http.Error(w, "Invalid Input", http.StatusBadRequest)
+		return
+	}
+
+	email := strings.TrimSpace(data.Email)
+	replyTo := strings.TrimSpace(data.ReplyTo)
+	if !isValidEmail(email) {
+		http.Error(w, "Invalid email", http.StatusBadRequest)
+		return
+	}
+	if replyTo != "" && !isValidEmail(replyTo) {
+		http.Error(w, "Invalid reply_to", http.StatusBadRequest)
+		return
+	}
+	if strings.ContainsAny(data.DisplayName, "\r\n") {
+		http.Error(w, "Invalid display_name", http.StatusBadRequest)
+		return
+	}
+	if (strings.TrimSpace(data.SMTPHost) == "") != (strings.TrimSpace(data.SMTPPort) == "") {
+		http.Error(w, "smtp_host and smtp_port must be given together", http.StatusBadRequest)
+		return
+	}
+	if strings.TrimSpace(data.SMTPHost) != "" {
+		if err := checkSMTPServer(strings.TrimSpace(data.SMTPHost), strings.TrimSpace(data.SMTPPort)); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+	}
+
+	var count int64
+	if err := database.DB.Model(&models.SenderIdentity{}).Where("owner_id = ? AND email = ?", curr_user.Id, email).Count(&count).Error; err != nil {
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+	if count > 0 {
+		http.Error(w, "Sender already registered", http.StatusConflict)
+		return
+	}
+
+	sealedPassword := ""
+	if data.SMTPPassword != "" {
+		if sealedPassword, err = vault.Seal(data.SMTPPassword); err != nil {
+			log.Println("Error sealing SMTP password:", err)
+			http.Error(w, "SMTP credentials can't be stored on this server", http.StatusInternalServerError)
+			return
+		}
+	}
+
+	tokenid, err := generateToken()
+	if err != nil {
+		panic(err)
+	}
+	verifyToken, err := generateToken()
+	if err != nil {
+		panic(err)
+	}
+
+	verifyExpiresAt := time.Now().Add(senderVerifyTTL)
+	identity := models.SenderIdentity{
+		Sender_ID:       "s-" + tokenid,
+		Owner_ID:        curr_user.Id,
+		DisplayName:     strings.TrimSpace(data.DisplayName),
+		Email:           email,
+		ReplyTo:         replyTo,
+		SMTPHost:        strings.TrimSpace(data.SMTPHost),
+		SMTPPort:        strings.TrimSpace(data.SMTPPort),
+		SMTPUsername:    strings.TrimSpace(data.SMTPUsername),
+		SMTPPassword:    sealedPassword,
+		VerifyToken:     verifyToken,
+		VerifyExpiresAt: &verifyExpiresAt,
+	}
+	if err := database.DB.Create(&identity).Error; err != nil {
+		http.Error(w, "Error saving sender", http.StatusInternalServerError)
+		return
+	}
+
+	if err := sendSenderVerification(identity, curr_user.Email); err != nil {
+		log.Println("Error sending sender verification:", err)
+		database.DB.Delete(&identity)
+		http.Error(w, "Error sending verification email", http.StatusInternalServerError)
+		return
+	}
+
+	response := map[string]interface{}{
+		"status":  http.StatusCreated,
+		"message": "Verification mail sent to " + email,
+		"sender":  identity,
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(response)
+}
+
+// Verify Sender
+// @Summary confirm a sender identity
+// @Description opened from the link in the confirmation mail; marks the sender identity as verified.
+// @Tags Senders
+// @Produce html
+// @Param token path string true "Verification Token"
+// @Success 200 {string} string "Sender verified"
+// @Failure 400 {string} string "Invalid or expired token"
+// @Router /api/senders/verify/{token} [get]
+func VerifySender(w http.ResponseWriter, r *http.Request) {
+	var identity models.SenderIdentity
+	token := r.PathValue("token")
+	if token == "" || database.DB.Where("verify_token = ? AND verify_expires_at > ?", token, time.Now()).First(&identity).Error != nil {
+		renderSenderVerified(w, http.StatusBadRequest, map[string]string{"Error": "Invalid or expired token"})
+		return
+	}
+
+	now := time.Now()
+	if err := database.DB.Model(&identity).Updates(map[string]interface{}{"verified": true, "verify_token": "", "verify_expires_at": nil, "verified_at": now}).Error; err != nil {
+		renderSenderVerified(w, http.StatusInternalServerError, map[string]string{"Error": "Failed to verify sender"})
+		return
+	}
+
+	renderSenderVerified(w, http.StatusOK, map[string]string{"Email": identity.Email})
+}
+
+func renderSenderVerified(w http.ResponseWriter, status int, data map[string]string) {
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.WriteHeader(status)
+	if err := senderVerifiedTemplate.Execute(w, data); err != nil {
+		log.Println("Error rendering sender verification page:", err)
+	}
+}
+
+// Delete Sender
+// @Summary delete a sender identity
+// @Description removes the sender identity. Groups that used it are sent from the server's own address again.
+// @Tags Senders
+// @Produce json
+// @Param id path string true "Sender ID"
+// @Success 200 {object} map[string]string "message"
+// @Failure 401 {object} string "Unauthorized"
+// @Failure 404 {object} string "Sender not found"
+// @Router /api/senders/{id} [delete]
+// @security jwt_token
+func DeleteSender(w http.ResponseWriter, r *http.Request) {
+	curr_user, err := GetUser(w, r)
+	if err != nil {
+		return
+	}
+
+	var identity models.SenderIdentity
+	if err := database.DB.Where("sender_id = ? AND owner_id = ?", r.PathValue("id"), curr_user.Id).First(&identity).Error; err != nil {
+		http.Error(w, "Sender not found", http.StatusNotFound)
+		return
+	}
+
+	err = database.DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Model(&models.Group{}).Where("sender_id = ?", identity.Sender_ID).Update("sender_id", "").Error; err != nil {
+			return err
+		}
+		return tx.Delete(&identity).Error
+	})
+	if err != nil {
+		http.Error(w, "Error deleting sender", http.StatusInternalServerError)
+		return
+	}
+	dropSenderTransport(identity.Sender_ID)
+
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(map[string]string{"message": "Sender deleted"})
+}
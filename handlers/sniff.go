@@ -0,0 +1,142 @@
+package handlers
+
+import (
+	"bytes"
+	"encoding/csv"
+	"fmt"
+	"strings"
+	"unicode/utf8"
+
+	"golang.org/x/text/encoding"
+	"golang.org/x/text/encoding/charmap"
+	"golang.org/x/text/encoding/unicode"
+)
+
+// sniffSample is how many rows the sniffers look at.
+const sniffSample = 50
+
+var textEncodings = map[string]encoding.Encoding{
+	"utf-8":        unicode.UTF8BOM,
+	"utf-16le":     unicode.UTF16(unicode.LittleEndian, unicode.UseBOM),
+	"utf-16be":     unicode.UTF16(unicode.BigEndian, unicode.UseBOM),
+	"windows-1252": charmap.Windows1252,
+	"iso-8859-1":   charmap.ISO8859_1,
+}
+
+var encodingAliases = map[string]string{
+	"utf8":   "utf-8",
+	"utf-16": "utf-16le",
+	"cp1252": "windows-1252",
+	"latin1": "iso-8859-1",
+}
+
+var delimiterNames = map[string]rune{
+	",": ',', ";": ';', "|": '|', "\t": '\t', "tab": '\t',
+}
+
+// decodeText converts a text file to UTF-8. Without an explicit encoding
+// it is sniffed: a byte order mark, valid UTF-8, UTF-16 by its zero bytes,
+// and Windows-1252 for anything else, which is what spreadsheet programs
+// on Windows export by default.
+func decodeText(data []byte, name string) (string, string, error) {
+	name = strings.ToLower(strings.TrimSpace(name))
+	if alias, ok := encodingAliases[name]; ok {
+		name = alias
+	}
+	if name == "" {
+		name = sniffEncoding(data)
+	}
+	enc, ok := textEncodings[name]
+	if !ok {
+		return "", "", fmt.Errorf("%w: unsupported encoding %q", errBadImport, name)
+	}
+	decoded, err := enc.NewDecoder().Bytes(data)
+	if err != nil {
+		return "", "", fmt.Errorf("%w: not valid %s: %v", errBadImport, name, err)
+	}
+	return string(decoded), name, nil
+}
+
+func sniffEncoding(data []byte) string {
+	switch {
+	case bytes.HasPrefix(data, []byte{0xEF, 0xBB, 0xBF}):
+		return "utf-8"
+	case bytes.HasPrefix(data, []byte{0xFF, 0xFE}):
+		return "utf-16le"
+	case bytes.HasPrefix(data, []byte{0xFE, 0xFF}):
+		return "utf-16be"
+	case utf8.Valid(data) && bytes.IndexByte(data, 0) < 0:
+		return "utf-8"
+	}
+
+	// Mostly-ASCII UTF-16 has a zero in every other byte.
+	sample := data[:min(len(data), 4096)]
+	var evenZeros, oddZeros int
+	for i, b := range sample {
+		if b != 0 {
+			continue
+		}
+		if i%2 == 0 {
+			evenZeros++
+		} else {
+			oddZeros++
+		}
+	}
+	switch {
+	case oddZeros > len(sample)/4 && evenZeros < oddZeros/8:
+		return "utf-16le"
+	case evenZeros > len(sample)/4 && oddZeros < evenZeros/8:
+		return "utf-16be"
+	}
+	return "windows-1252"
+}
+
+// parseDelimiter reads a delimiter option; empty means sniff it.
+func parseDelimiter(name string) (rune, error) {
+	if name == "" {
+		return 0, nil
+	}
+	if r, ok := delimiterNames[strings.ToLower(name)]; ok {
+		return r, nil
+	}
+	return 0, fmt.Errorf("%w: unsupported delimiter %q", errBadImport, name)
+}
+
+// sniffDelimiter picks the delimiter that splits the first rows into the
+// same number of columns most consistently, preferring more columns. A
+// single column list has no delimiter to find and gets a comma.
+func sniffDelimiter(text string) rune {
+	best, bestScore := ',', 0
+	for _, delimiter := range []rune{',', ';', '\t', '|'} {
+		reader := csv.NewReader(strings.NewReader(text))
+		reader.Comma = delimiter
+		reader.FieldsPerRecord = -1
+		reader.LazyQuotes = true
+
+		counts := make(map[int]int)
+		for i := 0; i < sniffSample; i++ {
+			record, err := reader.Read()
+			if err != nil {
+				break
+			}
+			counts[len(record)]++
+		}
+
+		for fields, rows := range counts {
+			if fields < 2 {
+				continue
+			}
+			if score := rows*100 + fields; score > bestScore {
+				best, bestScore = delimiter, score
+			}
+		}
+	}
+	return best
+}
+
+func delimiterName(delimiter rune) string {
+	if delimiter == '\t' {
+		return "tab"
+	}
+	return string(delimiter)
+}
@@ -0,0 +1,175 @@
+package handlers
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/karan-singh-17/Quick-Mail/database"
+	"github.com/karan-singh-17/Quick-Mail/fetcher"
+	"github.com/karan-singh-17/Quick-Mail/models"
+	"gorm.io/gorm"
+)
+
+// fetchSource downloads one of a group's links with a conditional request
+// based on the previous fetch. changed is false when the server answered
+// 304 or returned the same content; the caller should then keep what the
+// group already holds. optionsHash is what the content is read with (see
+// importOptionsHash); when it differs from the previous fetch the link is
+// downloaded in full and reported as changed. On success the returned cache
+// entry must be saved once the content has been applied.
+func fetchSource(groupID, kind, link, optionsHash string) (body []byte, cache models.SourceCache, changed bool, err error) {
+	cache = models.SourceCache{Group_ID: groupID, Kind: kind}
+	database.DB.Where("group_id = ? AND kind = ?", groupID, kind).First(&cache)
+	if cache.URL != link || cache.OptionsHash != optionsHash {
+		cache = models.SourceCache{Group_ID: groupID, Kind: kind, URL: link, OptionsHash: optionsHash}
+	}
+
+	req, err := http.NewRequest(http.MethodGet, link, nil)
+	if err != nil {
+		return nil, cache, false, err
+	}
+	if cache.ETag != "" {
+		req.Header.Set("If-None-Match", cache.ETag)
+	}
+	if cache.LastModified != "" {
+		req.Header.Set("If-Modified-Since", cache.LastModified)
+	}
+
+	contentTypes := htmlContentTypes
+	if kind == models.SourceCSV {
+		contentTypes = csvContentTypes
+	}
+	resp, err := fetcher.Do(req, contentTypes...)
+	if err != nil {
+		return nil, cache, false, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode == http.StatusNotModified {
+		return nil, cache, false, nil
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, cache, false, fmt.Errorf("error fetching %s, status code: %d", link, resp.StatusCode)
+	}
+
+	body, err = io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, cache, false, err
+	}
+
+	sum := sha256.Sum256(body)
+	hash := hex.EncodeToString(sum[:])
+	changed = hash != cache.ContentHash
+
+	cache.ETag = resp.Header.Get("ETag")
+	cache.LastModified = resp.Header.Get("Last-Modified")
+	cache.ContentHash = hash
+	cache.FetchedAt = time.Now()
+	return body, cache, changed, nil
+}
+
+// importOptionsHash identifies the options a recipient list is read with.
+func importOptionsHash(options models.ImportOptions) string {
+	data, _ := json.Marshal(options)
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+// refreshGroupSources fetches the group's CSV and HTML links again before
+// it is sent. When a link fails or yields unusable content the group keeps
+// its last good recipients or message, so the run still goes out.
+func refreshGroupSources(group *models.Group) {
+	if group.CSVLink != "" {
+		if err := refreshRecipients(group); err != nil {
+			log.Printf("Error refreshing recipients of group %s, using the last good list: %v", group.Group_ID, err)
+		}
+	}
+
+	if group.HTMLLink != "" {
+		if err := refreshMessage(group); err != nil {
+			log.Printf("Error refreshing message of group %s, using the last good message: %v", group.Group_ID, err)
+		}
+	}
+}
+
+func refreshRecipients(group *models.Group) error {
+	recipients, cache, changed, err := loadRecipientsSource(*group)
+	if err != nil || !changed {
+		return err
+	}
+
+	return database.DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Where("group_id = ? AND source = ?", group.Group_ID, models.RecipientSourceCSV).Delete(&models.Recipient{}).Error; err != nil {
+			return err
+		}
+		_, merged, err := saveRecipients(tx, *group, recipients)
+		if err != nil {
+			return err
+		}
+		if merged > 0 {
+			log.Printf("Merged %d duplicate recipients while refreshing group %s", merged, group.Group_ID)
+		}
+		return tx.Save(&cache).Error
+	})
+}
+
+// loadRecipientsSource fetches and reads the group's csv_link without
+// applying it. changed is false when the group already holds the list.
+func loadRecipientsSource(group models.Group) ([]importedRecipient, models.SourceCache, bool, error) {
+	link := recipientsLink(group.CSVLink, group.ImportOptions)
+
+	body, cache, changed, err := fetchSource(group.Group_ID, models.SourceCSV, link, importOptionsHash(group.ImportOptions))
+	if err != nil || !changed {
+		return nil, cache, false, err
+	}
+
+	recipients, err := readRecipients(body, group.ImportOptions)
+	if err != nil {
+		return nil, cache, false, err
+	}
+	if len(recipients) == 0 {
+		return nil, cache, false, fmt.Errorf("csv has no recipients")
+	}
+	for i := range recipients {
+		recipients[i].Source = models.RecipientSourceCSV
+	}
+	return recipients, cache, true, nil
+}
+
+func refreshMessage(group *models.Group) error {
+	message, cache, changed, err := loadMessageSource(*group)
+	if err != nil || !changed {
+		return err
+	}
+
+	return database.DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Model(&models.Group{}).Where("group_id = ?", group.Group_ID).Update("message", message).Error; err != nil {
+			return err
+		}
+		group.Message = message
+		return tx.Save(&cache).Error
+	})
+}
+
+// loadMessageSource fetches the group's html_link without applying it.
+// changed is false when the group already holds the message.
+func loadMessageSource(group models.Group) (string, models.SourceCache, bool, error) {
+	body, cache, changed, err := fetchSource(group.Group_ID, models.SourceHTML, group.HTMLLink, "")
+	if err != nil || !changed {
+		return "", cache, false, err
+	}
+
+	message := string(body)
+	if !group.PlainMessage {
+		if _, err := parseMergeTemplate(group.Subject, message); err != nil {
+			return "", cache, false, err
+		}
+	}
+	return message, cache, true, nil
+}
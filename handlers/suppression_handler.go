@@ -0,0 +1,231 @@
+package handlers
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"html/template"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/karan-singh-17/Quick-Mail/database"
+	"github.com/karan-singh-17/Quick-Mail/models"
+	"gorm.io/gorm/clause"
+)
+
+var unsubscribeTemplate = template.Must(template.ParseFiles("templates/unsubscribe.html"))
+
+// baseURL is where links in outgoing mails point back to.
+var baseURL = strings.TrimRight(envOr("baseURL", "https://quickmailserver-production.up.railway.app"), "/")
+
+// unsubscribeSecret signs unsubscribe links so they can't be forged for
+// other addresses. There is no default: a known secret would let anyone
+// suppress any address.
+var unsubscribeSecret = []byte(strings.TrimSpace(os.Getenv("unsubscribeSecret")))
+
+// CheckConfig reports settings the server can't safely run without.
+func CheckConfig() error {
+	if len(unsubscribeSecret) == 0 || string(unsubscribeSecret) == "your-256-bit-secret" {
+		return errors.New("unsubscribeSecret must be set to a long random value")
+	}
+	return nil
+}
+
+// unsubscribeURL returns the one-click unsubscribe link for a recipient of
+// one of owner's groups.
+func unsubscribeURL(ownerID, email, groupID string) string {
+	payload := base64.RawURLEncoding.EncodeToString([]byte(ownerID + "\n" + email + "\n" + groupID))
+	mac := hmac.New(sha256.New, unsubscribeSecret)
+	mac.Write([]byte(payload))
+	token := payload + "." + base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+	return baseURL + "/api/unsubscribe?token=" + url.QueryEscape(token)
+}
+
+func parseUnsubscribeToken(token string) (ownerID, email, groupID string, err error) {
+	payload, signature, found := strings.Cut(token, ".")
+	if !found {
+		return "", "", "", fmt.Errorf("malformed token")
+	}
+
+	mac := hmac.New(sha256.New, unsubscribeSecret)
+	mac.Write([]byte(payload))
+	sum, err := base64.RawURLEncoding.DecodeString(signature)
+	if err != nil || !hmac.Equal(sum, mac.Sum(nil)) {
+		return "", "", "", fmt.Errorf("invalid signature")
+	}
+
+	raw, err := base64.RawURLEncoding.DecodeString(payload)
+	if err != nil {
+		return "", "", "", fmt.Errorf("malformed token")
+	}
+	parts := strings.Split(string(raw), "\n")
+	// An empty owner would turn the unsubscribe into a suppression for
+	// every account.
+	if len(parts) != 3 || parts[0] == "" || parts[1] == "" {
+		return "", "", "", fmt.Errorf("malformed token")
+	}
+	return parts[0], parts[1], parts[2], nil
+}
+
+// suppressedEmails returns which of emails must not be mailed on behalf of
+// ownerID, mapped to the reason.
+func suppressedEmails(ownerID string, emails []string) (map[string]string, error) {
+	suppressed := make(map[string]string)
+	for start := 0; start < len(emails); start += 1000 {
+		end := start + 1000
+		if end > len(emails) {
+			end = len(emails)
+		}
+
+		var rows []models.Suppression
+		if err := database.DB.Where("email IN ? AND (owner_id = ? OR owner_id = '')", emails[start:end], ownerID).Find(&rows).Error; err != nil {
+			return nil, err
+		}
+		for _, row := range rows {
+			suppressed[row.Email] = row.Reason
+		}
+	}
+	return suppressed, nil
+}
+
+func suppress(ownerID, email, reason, groupID string) error {
+	entry := models.Suppression{
+		Owner_ID:  ownerID,
+		Email:     email,
+		Reason:    reason,
+		Group_ID:  groupID,
+		CreatedAt: time.Now(),
+	}
+	return database.DB.Clauses(clause.OnConflict{DoNothing: true}).Create(&entry).Error
+}
+
+// Unsubscribe
+// @Summary unsubscribe from a user's emails
+// @Description the link included in every group email. GET shows a confirmation page; POST unsubscribes the address from all groups of the sender. POST also serves RFC 8058 one-click unsubscribe requests sent by mail clients.
+// @Tags Suppressions
+// @Produce html
+// @Param token query string true "Signed unsubscribe token"
+// @Success 200 {string} string "Unsubscribe page"
+// @Failure 400 {string} string "Invalid or expired link"
+// @Router /api/unsubscribe [get]
+// @Router /api/unsubscribe [post]
+func Unsubscribe(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodPost {
+		http.Error(w, "Invalid Method", http.StatusMethodNotAllowed)
+		return
+	}
+
+	token := r.URL.Query().Get("token")
+	ownerID, email, groupID, err := parseUnsubscribeToken(token)
+	if err != nil {
+		renderUnsubscribe(w, http.StatusBadRequest, map[string]interface{}{"Error": "This unsubscribe link is invalid."})
+		return
+	}
+
+	if r.Method == http.MethodGet {
+		renderUnsubscribe(w, http.StatusOK, map[string]interface{}{"Email": email, "Token": token})
+		return
+	}
+
+	if err := suppress(ownerID, email, models.SuppressionUnsubscribe, groupID); err != nil {
+		log.Println("Error storing unsubscribe:", err)
+		renderUnsubscribe(w, http.StatusInternalServerError, map[string]interface{}{"Error": "Something went wrong, please try again."})
+		return
+	}
+	log.Printf("%s unsubscribed from owner %s", email, ownerID)
+
+	renderUnsubscribe(w, http.StatusOK, map[string]interface{}{"Email": email, "Done": true})
+}
+
+func renderUnsubscribe(w http.ResponseWriter, status int, data map[string]interface{}) {
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.WriteHeader(status)
+	if err := unsubscribeTemplate.Execute(w, data); err != nil {
+		log.Println("Error rendering unsubscribe page:", err)
+	}
+}
+
+type SuppressionData struct {
+	Emails []string `json:"emails"`
+}
+
+// Suppression List
+// @Summary manage the current user's suppression list
+// @Description GET lists the addresses the current user's groups will never mail (unsubscribes, manual entries and hard bounces from the user's own SMTP servers). POST adds addresses to the list (an invalid address rejects the request) and DELETE removes them. Global entries from hard bounces and complaints are not listed and cannot be removed.
+// @Tags Suppressions
+// @Accept json
+// @Produce json
+// @Param Emails body SuppressionData false "Emails (POST and DELETE)"
+// @Success 200 {object} map[string]interface{} "suppressions"
+// @Failure 400 {object} string "Invalid Input"
+// @Failure 401 {object} string "Unauthorized"
+// @Router /api/suppressions [get]
+// @Router /api/suppressions [post]
+// @Router /api/suppressions [delete]
+// @security jwt_token
+func Suppressions(w http.ResponseWriter, r *http.Request) {
+	curr_user, err := GetUser(w, r)
+	if err != nil {
+		return
+	}
+
+	response := map[string]interface{}{"status": http.StatusOK}
+
+	switch r.Method {
+	case http.MethodGet:
+		var entries []models.Suppression
+		if err := database.DB.Where("owner_id = ?", curr_user.Id).Order("created_at desc").Find(&entries).Error; err != nil {
+			http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+			return
+		}
+		response["suppressions"] = entries
+
+	case http.MethodPost, http.MethodDelete:
+		var data SuppressionData
+		if err := json.NewDecoder(r.Body).Decode(&data); err != nil || len(data.Emails) == 0 {
+			http.Error(w, "Invalid Input", http.StatusBadRequest)
+			return
+		}
+
+		// Entries are stored the way recipients are, so lookups by a
+		// recipient's address find them.
+		emails := make([]string, len(data.Emails))
+		for i, email := range data.Emails {
+			emails[i] = normalizeEmail(email)
+			if r.Method == http.MethodPost && !isValidEmail(emails[i]) {
+				http.Error(w, "Invalid email address: "+strings.TrimSpace(email), http.StatusBadRequest)
+				return
+			}
+		}
+
+		if r.Method == http.MethodPost {
+			for _, email := range emails {
+				if err := suppress(curr_user.Id, email, models.SuppressionManual, ""); err != nil {
+					http.Error(w, "Error updating suppression list", http.StatusInternalServerError)
+					return
+				}
+			}
+			response["message"] = "Addresses suppressed"
+		} else {
+			if err := database.DB.Where("owner_id = ? AND email IN ?", curr_user.Id, emails).Delete(&models.Suppression{}).Error; err != nil {
+				http.Error(w, "Error updating suppression list", http.StatusInternalServerError)
+				return
+			}
+			response["message"] = "Addresses removed from the suppression list"
+		}
+
+	default:
+		http.Error(w, "Invalid Method", http.StatusMethodNotAllowed)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(response)
+}
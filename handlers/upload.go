@@ -0,0 +1,221 @@
+package handlers
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/karan-singh-17/Quick-Mail/models"
+	"github.com/karan-singh-17/Quick-Mail/spreadsheet"
+)
+
+// maxCSVUploadSize and maxHTMLUploadSize cap recipient lists and messages
+// uploaded with a group or read from the import directory.
+var (
+	maxCSVUploadSize  = int64(envInt("maxCSVUploadSize", 5<<20))
+	maxHTMLUploadSize = int64(envInt("maxHTMLUploadSize", 2<<20))
+)
+
+// importDir is the only directory csv_file_path and html_path may be read
+// from. When it is unset those options are disabled.
+var importDir = envOr("importDir", "")
+
+var errImportDisabled = errors.New("file paths are not enabled on this server, upload the file instead")
+
+var (
+	spreadsheetExtensions   = map[string]bool{".xlsx": true, ".ods": true}
+	recipientListExtensions = map[string]bool{".csv": true, ".txt": true, ".xlsx": true, ".ods": true}
+	htmlExtensions          = map[string]bool{".html": true, ".htm": true}
+)
+
+// isMultipart reports whether the request is a multipart/form-data upload.
+func isMultipart(r *http.Request) bool {
+	return strings.HasPrefix(r.Header.Get("Content-Type"), "multipart/form-data")
+}
+
+// readGroupForm reads a multipart group request: the fields, as JSON, from
+// the "group" part into v, and the optional csv_file and html_file uploads.
+func readGroupForm(w http.ResponseWriter, r *http.Request, v interface{}) (csvFile, htmlFile *multipart.FileHeader, err error) {
+	r.Body = http.MaxBytesReader(w, r.Body, maxCSVUploadSize+maxHTMLUploadSize+1<<20)
+	if err := r.ParseMultipartForm(8 << 20); err != nil {
+		return nil, nil, fmt.Errorf("invalid upload: %v", err)
+	}
+	if fields := r.FormValue("group"); fields != "" {
+		if err := json.Unmarshal([]byte(fields), v); err != nil {
+			return nil, nil, fmt.Errorf("invalid group field: %v", err)
+		}
+	}
+	if files := r.MultipartForm.File["csv_file"]; len(files) > 0 {
+		csvFile = files[0]
+	}
+	if files := r.MultipartForm.File["html_file"]; len(files) > 0 {
+		htmlFile = files[0]
+	}
+	return csvFile, htmlFile, nil
+}
+
+// readLimited reads an uploaded or imported file, making sure it has one of
+// the expected extensions and fits the limit.
+func readLimited(r io.Reader, filename string, limit int64, extensions map[string]bool) ([]byte, error) {
+	ext := strings.ToLower(filepath.Ext(filename))
+	if !extensions[ext] {
+		return nil, fmt.Errorf("%s has the wrong file type", filepath.Base(filename))
+	}
+
+	data, err := io.ReadAll(io.LimitReader(r, limit+1))
+	if err != nil {
+		return nil, err
+	}
+	if int64(len(data)) > limit {
+		return nil, fmt.Errorf("%s is larger than %d bytes", filepath.Base(filename), limit)
+	}
+	if len(bytes.TrimSpace(data)) == 0 {
+		return nil, fmt.Errorf("%s is empty", filepath.Base(filename))
+	}
+	return data, nil
+}
+
+// isText reports whether decoded content is text rather than some binary
+// renamed to look like it.
+func isText(text []byte) bool {
+	return utf8.Valid(text) && bytes.IndexByte(text, 0) < 0 && strings.HasPrefix(http.DetectContentType(text), "text/")
+}
+
+// readTextFile reads a UTF-8 text file; see readLimited.
+func readTextFile(r io.Reader, filename string, limit int64, extensions map[string]bool) ([]byte, error) {
+	data, err := readLimited(r, filename, limit, extensions)
+	if err != nil {
+		return nil, err
+	}
+	data = bytes.TrimPrefix(data, []byte("\ufeff"))
+	if !isText(data) {
+		return nil, fmt.Errorf("%s is not a UTF-8 text file", filepath.Base(filename))
+	}
+	return data, nil
+}
+
+// readRecipientList reads a CSV, XLSX or ODS recipient list, checking that
+// its content matches its extension. CSVs may be in any encoding
+// decodeText understands.
+func readRecipientList(r io.Reader, filename string, options models.ImportOptions) ([]byte, error) {
+	data, err := readLimited(r, filename, maxCSVUploadSize, recipientListExtensions)
+	if err != nil {
+		return nil, err
+	}
+	if spreadsheetExtensions[strings.ToLower(filepath.Ext(filename))] {
+		if !spreadsheet.IsSpreadsheet(data) {
+			return nil, fmt.Errorf("%s is not a spreadsheet", filepath.Base(filename))
+		}
+		return data, nil
+	}
+	text, _, err := decodeText(data, options.Encoding)
+	if err != nil || !isText([]byte(strings.TrimPrefix(text, "\ufeff"))) {
+		return nil, fmt.Errorf("%s is not a text file", filepath.Base(filename))
+	}
+	return data, nil
+}
+
+// parseRecipientsFile validates a CSV, XLSX or ODS file's content and reads
+// its recipients; it must hold at least one valid address.
+func parseRecipientsFile(r io.Reader, filename string, options models.ImportOptions) ([]importedRecipient, error) {
+	data, err := readRecipientList(r, filename, options)
+	if err != nil {
+		return nil, err
+	}
+	recipients, err := readRecipients(data, options)
+	if err != nil {
+		return nil, fmt.Errorf("%s can't be read: %v", filepath.Base(filename), err)
+	}
+	for _, recipient := range recipients {
+		if isValidEmail(normalizeEmail(recipient.Email)) {
+			return recipients, nil
+		}
+	}
+	return nil, fmt.Errorf("%s has no valid email addresses", filepath.Base(filename))
+}
+
+// parseHTMLFile validates an HTML file's content and returns it.
+func parseHTMLFile(r io.Reader, filename string) (string, error) {
+	data, err := readTextFile(r, filename, maxHTMLUploadSize, htmlExtensions)
+	if err != nil {
+		return "", err
+	}
+	return string(data), nil
+}
+
+func readCSVUpload(header *multipart.FileHeader, options models.ImportOptions) ([]importedRecipient, error) {
+	file, err := header.Open()
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	return parseRecipientsFile(file, header.Filename, options)
+}
+
+func readHTMLUpload(header *multipart.FileHeader) (string, error) {
+	file, err := header.Open()
+	if err != nil {
+		return "", err
+	}
+	defer file.Close()
+	return parseHTMLFile(file, header.Filename)
+}
+
+// uploadName is the filename of an optional upload, for the checks that
+// only one message or recipient source is given.
+func uploadName(header *multipart.FileHeader) string {
+	if header == nil {
+		return ""
+	}
+	return header.Filename
+}
+
+// importPath resolves a server-side path, relative to importDir or absolute,
+// and makes sure it stays inside importDir once symlinks are followed.
+func importPath(name string) (string, error) {
+	if importDir == "" {
+		return "", errImportDisabled
+	}
+	dir, err := filepath.Abs(importDir)
+	if err != nil {
+		return "", fmt.Errorf("import directory is not available")
+	}
+	root, err := filepath.EvalSymlinks(dir)
+	if err != nil {
+		return "", fmt.Errorf("import directory is not available")
+	}
+
+	target := filepath.Clean(strings.TrimSpace(name))
+	if !filepath.IsAbs(target) {
+		target = filepath.Join(root, target)
+	}
+	// Check the path as given before touching the disk, so paths outside the
+	// directory can't be probed for existence.
+	if !insideDir(dir, target) && !insideDir(root, target) {
+		return "", fmt.Errorf("%s is outside the import directory", name)
+	}
+	target, err = filepath.EvalSymlinks(target)
+	if err != nil {
+		return "", fmt.Errorf("%s not found in the import directory", name)
+	}
+	if !insideDir(root, target) {
+		return "", fmt.Errorf("%s is outside the import directory", name)
+	}
+	if info, err := os.Stat(target); err != nil || !info.Mode().IsRegular() {
+		return "", fmt.Errorf("%s is not a file", name)
+	}
+	return target, nil
+}
+
+func insideDir(dir, target string) bool {
+	rel, err := filepath.Rel(dir, target)
+	return err == nil && rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator))
+}
@@ -1,22 +1,24 @@
 package handlers
 
 import (
+	"crypto/rand"
 	"crypto/sha256"
 	"encoding/base32"
-	"encoding/csv"
 	"encoding/hex"
+	"errors"
 	"fmt"
 	"io"
-	"math/rand"
+	"math/big"
 	"net/http"
 	"os"
 	"regexp"
+	"strconv"
 	"strings"
 	"sync"
-	"time"
 
 	"github.com/dgrijalva/jwt-go"
 	"github.com/karan-singh-17/Quick-Mail/database"
+	"github.com/karan-singh-17/Quick-Mail/fetcher"
 	"github.com/karan-singh-17/Quick-Mail/models"
 )
 
@@ -30,10 +32,14 @@ var tempStore = struct {
 	data map[string]models.User
 }{data: make(map[string]models.User)}
 
+// generateCode and generateToken read crypto/rand, since login codes and
+// verification tokens must not be guessable from the time they were made.
 func generateCode() string {
-	rand.Seed(time.Now().UnixNano())
-	code := rand.Intn(1000000)
-	return fmt.Sprintf("%06d", code)
+	code, err := rand.Int(rand.Reader, big.NewInt(1000000))
+	if err != nil {
+		panic(err)
+	}
+	return fmt.Sprintf("%06d", code.Int64())
 }
 
 func GenerateID(email string) string {
@@ -92,20 +98,45 @@ func GetUser(w http.ResponseWriter, r *http.Request) (models.User, error) {
 }
 
 func isValidEmail(email string) bool {
-	const emailRegex = `^[a-zA-Z0-9._%+-]+@[a-zA-Z0-9.-]+\.[a-zA-Z]{2,}$`
+	const emailRegex = `^[a-zA-Z0-9._%+-]+@[a-zA-Z0-9.-]+\.([a-zA-Z]{2,}|xn--[a-zA-Z0-9-]+)$`
 	re := regexp.MustCompile(emailRegex)
 	return re.MatchString(email)
 }
 
-func fetchRecipientsFromCSV(csvLink string) ([]string, error) {
-	var recipients []string
+// csvContentTypes and htmlContentTypes are what recipient list and HTML
+// links may serve. Spreadsheet exports and file hosts often send CSVs as
+// generic binaries.
+var (
+	csvContentTypes = []string{
+		"text/csv", "text/plain", "application/csv", "application/vnd.ms-excel", "application/octet-stream",
+		"application/vnd.openxmlformats-officedocument.spreadsheetml.sheet", "application/vnd.oasis.opendocument.spreadsheet", "application/zip",
+	}
+	htmlContentTypes = []string{"text/html", "text/plain", "application/xhtml+xml"}
+)
+
+// fetchFailed reports a failed download of a user supplied link. Links that
+// were refused (internal address, too large, wrong type) or hold an
+// unreadable list are the user's to fix, anything else is reported as a
+// server error.
+func fetchFailed(w http.ResponseWriter, message string, err error) {
+	if errors.Is(err, fetcher.ErrBlocked) || errors.Is(err, fetcher.ErrTooLarge) || errors.Is(err, fetcher.ErrContentType) || errors.Is(err, errBadImport) {
+		http.Error(w, message+": "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	http.Error(w, message, http.StatusInternalServerError)
+}
 
-	if strings.Contains(csvLink, "docs.google.com/spreadsheets/") {
-		csvLink = convertGoogleSheetToCSV(csvLink)
+func fetchRecipientsFromCSV(csvLink string, options models.ImportOptions) ([]importedRecipient, error) {
+	body, err := fetchRecipientList(csvLink, options)
+	if err != nil {
+		return nil, err
 	}
+	return readRecipients(body, options)
+}
 
-	// Download the CSV file
-	resp, err := http.Get(csvLink)
+// fetchRecipientList downloads a CSV or spreadsheet of recipients.
+func fetchRecipientList(link string, options models.ImportOptions) ([]byte, error) {
+	resp, err := fetcher.Get(recipientsLink(link, options), csvContentTypes...)
 	if err != nil {
 		return nil, err
 	}
@@ -114,21 +145,22 @@ func fetchRecipientsFromCSV(csvLink string) ([]string, error) {
 	if resp.StatusCode != http.StatusOK {
 		return nil, fmt.Errorf("failed to download csv file")
 	}
-	reader := csv.NewReader(resp.Body)
-	for {
-		record, err := reader.Read()
-		if err == io.EOF {
-			break
-		}
-		if err != nil {
-			return nil, err
-		}
-		if len(record) > 0 {
-			recipients = append(recipients, strings.TrimSpace(record[0]))
-		}
-	}
 
-	return recipients, nil
+	return io.ReadAll(resp.Body)
+}
+
+// recipientsLink returns the URL to download a recipient list from. Google
+// Sheets are exported as CSV, or as XLSX when a sheet other than the first
+// one may be wanted.
+func recipientsLink(link string, options models.ImportOptions) string {
+	if !strings.Contains(link, "docs.google.com/spreadsheets/") {
+		return link
+	}
+	link = convertGoogleSheetToCSV(link)
+	if options.Sheet != "" {
+		link = strings.Replace(link, "export?format=csv", "export?format=xlsx", 1)
+	}
+	return link
 }
 func convertGoogleSheetToCSV(link string) string {
 	// Extract the sheet ID from the Google Sheets link
@@ -142,32 +174,18 @@ func convertGoogleSheetToCSV(link string) string {
 
 	return link // Return the original link if it doesn't match the pattern
 }
-func extractRecipientsFromFilePath(filePath string) ([]string, error) {
-	var recipients []string
-
+func extractRecipientsFromFilePath(filePath string, options models.ImportOptions) ([]importedRecipient, error) {
+	filePath, err := importPath(filePath)
+	if err != nil {
+		return nil, err
+	}
 	file, err := os.Open(filePath)
 	if err != nil {
 		return nil, err
 	}
 	defer file.Close()
 
-	reader := csv.NewReader(file)
-	for {
-		record, err := reader.Read()
-		if err == io.EOF {
-			break
-		}
-		if err != nil {
-			return nil, err
-		}
-
-		// Assuming the CSV contains emails in the first column
-		if len(record) > 0 {
-			recipients = append(recipients, strings.TrimSpace(record[0]))
-		}
-	}
-
-	return recipients, nil
+	return parseRecipientsFile(file, filePath, options)
 }
 func validateSingleFilledField(fields ...string) bool {
 	filledCount := 0
@@ -180,7 +198,7 @@ func validateSingleFilledField(fields ...string) bool {
 }
 
 func fetchHTMLFromLink(link string) (string, error) {
-	resp, err := http.Get(link)
+	resp, err := fetcher.Get(link, htmlContentTypes...)
 	if err != nil {
 		return "", err
 	}
@@ -199,10 +217,33 @@ func fetchHTMLFromLink(link string) (string, error) {
 }
 
 func readHTMLFromFilePath(filePath string) (string, error) {
-	content, err := os.ReadFile(filePath)
+	filePath, err := importPath(filePath)
+	if err != nil {
+		return "", err
+	}
+	file, err := os.Open(filePath)
 	if err != nil {
 		return "", err
 	}
+	defer file.Close()
+
+	return parseHTMLFile(file, filePath)
+}
+
+// envInt reads an integer setting from the environment, falling back to def
+// when it is unset or malformed.
+func envInt(key string, def int) int {
+	value, err := strconv.Atoi(strings.TrimSpace(os.Getenv(key)))
+	if err != nil {
+		return def
+	}
+	return value
+}
 
-	return string(content), nil
+// envOr reads a setting from the environment, falling back to def.
+func envOr(key, def string) string {
+	if value := strings.TrimSpace(os.Getenv(key)); value != "" {
+		return value
+	}
+	return def
 }
@@ -0,0 +1,231 @@
+package mailer
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// dkimHeaders are signed when present in the message.
+var dkimHeaders = []string{
+	"From", "Reply-To", "To", "Subject", "Date", "Message-ID",
+	"MIME-Version", "Content-Type", "List-Unsubscribe", "List-Unsubscribe-Post",
+}
+
+// DKIMSigner adds a DKIM-Signature (RFC 6376) to outgoing messages using
+// relaxed/relaxed canonicalization. Key is an *rsa.PrivateKey (rsa-sha256)
+// or an ed25519.PrivateKey (ed25519-sha256, RFC 8463).
+type DKIMSigner struct {
+	Domain   string
+	Selector string
+	Key      crypto.Signer
+}
+
+// Algorithm returns the a= tag for the signer's key.
+func (s *DKIMSigner) Algorithm() string {
+	if _, ok := s.Key.(ed25519.PrivateKey); ok {
+		return "ed25519-sha256"
+	}
+	return "rsa-sha256"
+}
+
+// Sign returns msg with a DKIM-Signature header prepended. msg must use CRLF
+// line endings, as Email.Bytes produces.
+func (s *DKIMSigner) Sign(msg []byte) ([]byte, error) {
+	head, body, ok := bytes.Cut(msg, []byte("\r\n\r\n"))
+	if !ok {
+		return nil, fmt.Errorf("dkim: message has no body")
+	}
+	fields := splitHeaderFields(string(head) + "\r\n")
+
+	bodyHash := sha256.Sum256(relaxedBody(body))
+
+	var signed []string
+	var canonical strings.Builder
+	for _, name := range dkimHeaders {
+		if field, ok := lastHeaderField(fields, name); ok {
+			signed = append(signed, strings.ToLower(name))
+			canonical.WriteString(relaxedHeader(field))
+		}
+	}
+
+	value := fmt.Sprintf("v=1; a=%s; c=relaxed/relaxed; d=%s; s=%s;\r\n t=%s; h=%s;\r\n bh=%s;\r\n b=",
+		s.Algorithm(), s.Domain, s.Selector, strconv.FormatInt(time.Now().Unix(), 10),
+		strings.Join(signed, ":"), base64.StdEncoding.EncodeToString(bodyHash[:]))
+	canonical.WriteString(strings.TrimSuffix(relaxedHeader("DKIM-Signature: "+value), "\r\n"))
+
+	digest := sha256.Sum256([]byte(canonical.String()))
+	var signature []byte
+	var err error
+	if _, ok := s.Key.(ed25519.PrivateKey); ok {
+		signature, err = s.Key.Sign(rand.Reader, digest[:], crypto.Hash(0))
+	} else {
+		signature, err = s.Key.Sign(rand.Reader, digest[:], crypto.SHA256)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("dkim: %v", err)
+	}
+
+	var out bytes.Buffer
+	out.WriteString("DKIM-Signature: " + value + foldBase64(base64.StdEncoding.EncodeToString(signature)) + "\r\n")
+	out.Write(msg)
+	return out.Bytes(), nil
+}
+
+// splitHeaderFields splits a header block into whole fields, keeping each
+// field's continuation lines and trailing CRLF.
+func splitHeaderFields(head string) []string {
+	var fields []string
+	for _, line := range strings.SplitAfter(head, "\r\n") {
+		if line == "" {
+			continue
+		}
+		if (line[0] == ' ' || line[0] == '\t') && len(fields) > 0 {
+			fields[len(fields)-1] += line
+			continue
+		}
+		fields = append(fields, line)
+	}
+	return fields
+}
+
+// lastHeaderField finds the bottom-most instance of a header, which is the
+// one verifiers pair with a single h= entry.
+func lastHeaderField(fields []string, name string) (string, bool) {
+	for i := len(fields) - 1; i >= 0; i-- {
+		key, _, ok := strings.Cut(fields[i], ":")
+		if ok && strings.EqualFold(strings.TrimSpace(key), name) {
+			return fields[i], true
+		}
+	}
+	return "", false
+}
+
+// relaxedHeader applies the relaxed header canonicalization: lowercase name,
+// unfolded value with whitespace runs reduced to one space.
+func relaxedHeader(field string) string {
+	key, value, _ := strings.Cut(field, ":")
+	value = strings.NewReplacer("\r\n", "").Replace(value)
+	return strings.ToLower(strings.TrimSpace(key)) + ":" + strings.Join(strings.Fields(value), " ") + "\r\n"
+}
+
+// relaxedBody applies the relaxed body canonicalization: whitespace runs
+// reduced to one space, trailing whitespace and trailing empty lines removed.
+func relaxedBody(body []byte) []byte {
+	lines := strings.Split(string(body), "\r\n")
+	for i, line := range lines {
+		line = strings.TrimRight(line, " \t")
+		var b strings.Builder
+		space := false
+		for _, r := range line {
+			if r == ' ' || r == '\t' {
+				space = true
+				continue
+			}
+			if space {
+				b.WriteByte(' ')
+				space = false
+			}
+			b.WriteRune(r)
+		}
+		lines[i] = b.String()
+	}
+	for len(lines) > 0 && lines[len(lines)-1] == "" {
+		lines = lines[:len(lines)-1]
+	}
+	if len(lines) == 0 {
+		return nil
+	}
+	return []byte(strings.Join(lines, "\r\n") + "\r\n")
+}
+
+func foldBase64(s string) string {
+	var b strings.Builder
+	for len(s) > 72 {
+		b.WriteString(s[:72] + "\r\n ")
+		s = s[72:]
+	}
+	b.WriteString(s)
+	return b.String()
+}
+
+// ParseDKIMKey reads a PEM encoded RSA (PKCS #1 or #8) or Ed25519 (PKCS #8)
+// private key.
+func ParseDKIMKey(data []byte) (crypto.Signer, error) {
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, fmt.Errorf("dkim: no PEM block found")
+	}
+
+	if key, err := x509.ParsePKCS1PrivateKey(block.Bytes); err == nil {
+		return checkRSAKey(key)
+	}
+	key, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		return nil, fmt.Errorf("dkim: unsupported private key: %v", err)
+	}
+	switch key := key.(type) {
+	case *rsa.PrivateKey:
+		return checkRSAKey(key)
+	case ed25519.PrivateKey:
+		return key, nil
+	}
+	return nil, fmt.Errorf("dkim: only RSA and Ed25519 keys are supported")
+}
+
+func checkRSAKey(key *rsa.PrivateKey) (crypto.Signer, error) {
+	if key.N.BitLen() < 1024 {
+		return nil, fmt.Errorf("dkim: RSA keys must be at least 1024 bits")
+	}
+	return key, nil
+}
+
+// GenerateDKIMKey creates a new key, "rsa" (2048 bits) or "ed25519", and
+// returns it PEM encoded (PKCS #8).
+func GenerateDKIMKey(algorithm string) ([]byte, error) {
+	var key crypto.Signer
+	var err error
+	switch algorithm {
+	case "rsa":
+		key, err = rsa.GenerateKey(rand.Reader, 2048)
+	case "ed25519":
+		_, key, err = ed25519.GenerateKey(rand.Reader)
+	default:
+		return nil, fmt.Errorf("dkim: unknown algorithm %q", algorithm)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	der, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		return nil, err
+	}
+	return pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der}), nil
+}
+
+// DKIMRecord returns the TXT record to publish at
+// <selector>._domainkey.<domain> for the key.
+func DKIMRecord(key crypto.Signer) (string, error) {
+	switch pub := key.Public().(type) {
+	case ed25519.PublicKey:
+		return "v=DKIM1; k=ed25519; p=" + base64.StdEncoding.EncodeToString(pub), nil
+	case *rsa.PublicKey:
+		der, err := x509.MarshalPKIXPublicKey(pub)
+		if err != nil {
+			return "", err
+		}
+		return "v=DKIM1; k=rsa; p=" + base64.StdEncoding.EncodeToString(der), nil
+	}
+	return "", fmt.Errorf("dkim: unsupported key type")
+}
@@ -0,0 +1,237 @@
+package mailer
+
+import (
+	"crypto"
+	"crypto/ed25519"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"regexp"
+	"strings"
+	"testing"
+)
+
+// The ed25519 example of RFC 8463, appendix A.
+const (
+	rfc8463Seed      = "nWGxne/9WmC6hEr0kuwsxERJxWl7MmkZcDusAxyuf2A="
+	rfc8463PublicKey = "11qYAYKxCrfVS/7TyWQHOg7hcvPapiMlrwIaaPcHURo="
+	rfc8463Message   = "From: Joe SixPack <joe@football.example.com>\r\n" +
+		"To: Suzie Q <suzie@shopping.example.net>\r\n" +
+		"Subject: Is dinner ready?\r\n" +
+		"Date: Fri, 11 Jul 2003 21:00:37 -0700 (PDT)\r\n" +
+		"Message-ID: <20030712040037.46341.5F8J@football.example.com>\r\n" +
+		"\r\n" +
+		"Hi.\r\n" +
+		"\r\n" +
+		"We lost the game.  Are you hungry yet?\r\n" +
+		"\r\n" +
+		"Joe.\r\n"
+	rfc8463Signature = "DKIM-Signature: v=1; a=ed25519-sha256; c=relaxed/relaxed;\r\n" +
+		" d=football.example.com; i=@football.example.com;\r\n" +
+		" q=dns/txt; s=brisbane; t=1528637909; h=from : to :\r\n" +
+		" subject : date : message-id : from : subject : date;\r\n" +
+		" bh=2jUSOH9NhtVGCQWNr9BrIAPreKQjO6Sn7XIkfJVOzv8=;\r\n" +
+		" b=/gCrinpcQOoIfuHNQIbq4pgh9kyIK3AQUdt9OdqQehSwhEIug4D11Bus\r\n" +
+		" Fa3bT3FY5OsU7ZbnKELq+eXdp1Q1Dw==\r\n"
+)
+
+// signatureValue matches the b= tag of a DKIM-Signature field, which is
+// signed empty.
+var signatureValue = regexp.MustCompile(`([;\s]b=)[^;]*`)
+
+func rfc8463Key(t *testing.T) ed25519.PrivateKey {
+	t.Helper()
+	seed, err := base64.StdEncoding.DecodeString(rfc8463Seed)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return ed25519.NewKeyFromSeed(seed)
+}
+
+// dkimTags reads the tags of a DKIM-Signature field.
+func dkimTags(field string) map[string]string {
+	_, value, _ := strings.Cut(field, ":")
+	tags := make(map[string]string)
+	for _, tag := range strings.Split(value, ";") {
+		name, v, ok := strings.Cut(tag, "=")
+		if ok {
+			tags[strings.TrimSpace(name)] = strings.Join(strings.Fields(v), "")
+		}
+	}
+	return tags
+}
+
+// verifyDKIM checks the first DKIM-Signature of msg the way a receiver
+// does, using the canonicalization functions under test.
+func verifyDKIM(t *testing.T, msg []byte, public crypto.PublicKey) {
+	t.Helper()
+	head, body, ok := strings.Cut(string(msg), "\r\n\r\n")
+	if !ok {
+		t.Fatal("message has no body")
+	}
+	fields := splitHeaderFields(head + "\r\n")
+	signature := fields[0]
+	if !strings.HasPrefix(signature, "DKIM-Signature:") {
+		t.Fatalf("first field is %q, want the signature", signature)
+	}
+	tags := dkimTags(signature)
+
+	bodyHash := sha256.Sum256(relaxedBody([]byte(body)))
+	if got := base64.StdEncoding.EncodeToString(bodyHash[:]); got != tags["bh"] {
+		t.Fatalf("body hash = %s, signature has %s", got, tags["bh"])
+	}
+
+	// Every h= entry takes the next instance of its field from the bottom;
+	// names listed more often than the field occurs add nothing.
+	var canonical strings.Builder
+	used := make(map[string]int)
+	for _, name := range strings.Split(tags["h"], ":") {
+		name = strings.ToLower(strings.TrimSpace(name))
+		seen := 0
+		for i := len(fields) - 1; i >= 1; i-- {
+			key, _, _ := strings.Cut(fields[i], ":")
+			if strings.ToLower(strings.TrimSpace(key)) != name {
+				continue
+			}
+			if seen == used[name] {
+				canonical.WriteString(relaxedHeader(fields[i]))
+				break
+			}
+			seen++
+		}
+		used[name]++
+	}
+	unsigned := signatureValue.ReplaceAllString(signature, "$1")
+	canonical.WriteString(strings.TrimSuffix(relaxedHeader(unsigned), "\r\n"))
+
+	sig, err := base64.StdEncoding.DecodeString(tags["b"])
+	if err != nil {
+		t.Fatalf("b= is not base64: %v", err)
+	}
+	digest := sha256.Sum256([]byte(canonical.String()))
+	switch key := public.(type) {
+	case ed25519.PublicKey:
+		if !ed25519.Verify(key, digest[:], sig) {
+			t.Errorf("ed25519 signature doesn't verify over %q", canonical.String())
+		}
+	case *rsa.PublicKey:
+		if err := rsa.VerifyPKCS1v15(key, crypto.SHA256, digest[:], sig); err != nil {
+			t.Errorf("rsa signature doesn't verify: %v", err)
+		}
+	default:
+		t.Fatalf("unexpected key type %T", public)
+	}
+}
+
+func TestDKIMKnownVector(t *testing.T) {
+	key := rfc8463Key(t)
+	if got := base64.StdEncoding.EncodeToString(key.Public().(ed25519.PublicKey)); got != rfc8463PublicKey {
+		t.Fatalf("public key = %s, want %s", got, rfc8463PublicKey)
+	}
+	verifyDKIM(t, []byte(rfc8463Signature+rfc8463Message), key.Public())
+
+	record, err := DKIMRecord(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := "v=DKIM1; k=ed25519; p=" + rfc8463PublicKey; record != want {
+		t.Errorf("DKIMRecord = %q, want %q", record, want)
+	}
+}
+
+func TestDKIMSign(t *testing.T) {
+	rsaPEM, err := GenerateDKIMKey("rsa")
+	if err != nil {
+		t.Fatal(err)
+	}
+	rsaKey, err := ParseDKIMKey(rsaPEM)
+	if err != nil {
+		t.Fatal(err)
+	}
+	edKey := rfc8463Key(t)
+
+	tests := []struct {
+		name      string
+		key       crypto.Signer
+		algorithm string
+	}{
+		{"ed25519", edKey, "ed25519-sha256"},
+		{"rsa", rsaKey, "rsa-sha256"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			signer := &DKIMSigner{Domain: "football.example.com", Selector: "brisbane", Key: tt.key}
+			signed, err := signer.Sign([]byte(rfc8463Message))
+			if err != nil {
+				t.Fatalf("Sign: %v", err)
+			}
+			if !strings.HasSuffix(string(signed), rfc8463Message) {
+				t.Error("Sign changed the message")
+			}
+
+			tags := dkimTags(strings.SplitN(string(signed), "\r\n\r\n", 2)[0])
+			if tags["a"] != tt.algorithm || tags["d"] != "football.example.com" || tags["s"] != "brisbane" || tags["c"] != "relaxed/relaxed" {
+				t.Errorf("tags = %v", tags)
+			}
+			if want := "from:to:subject:date:message-id"; tags["h"] != want {
+				t.Errorf("h = %q, want %q", tags["h"], want)
+			}
+			if want := "2jUSOH9NhtVGCQWNr9BrIAPreKQjO6Sn7XIkfJVOzv8="; tags["bh"] != want {
+				t.Errorf("bh = %q, want the RFC 8463 body hash %q", tags["bh"], want)
+			}
+			verifyDKIM(t, signed, tt.key.Public())
+		})
+	}
+}
+
+func TestRelaxedCanonicalization(t *testing.T) {
+	// The example of RFC 6376, section 3.4.5.
+	headers := []struct{ in, want string }{
+		{"A: X\r\n", "a:X\r\n"},
+		{"B : Y\t\r\n\tZ  \r\n", "b:Y Z\r\n"},
+	}
+	for _, h := range headers {
+		if got := relaxedHeader(h.in); got != h.want {
+			t.Errorf("relaxedHeader(%q) = %q, want %q", h.in, got, h.want)
+		}
+	}
+
+	bodies := []struct{ in, want string }{
+		{" C \r\nD \t E\r\n\r\n\r\n", " C\r\nD E\r\n"},
+		{"", ""},
+		{"\r\n\r\n", ""},
+		{"no newline", "no newline\r\n"},
+	}
+	for _, b := range bodies {
+		if got := string(relaxedBody([]byte(b.in))); got != b.want {
+			t.Errorf("relaxedBody(%q) = %q, want %q", b.in, got, b.want)
+		}
+	}
+}
+
+func TestParseDKIMKey(t *testing.T) {
+	edKey := rfc8463Key(t)
+	pkcs8, err := x509.MarshalPKCS8PrivateKey(edKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := ParseDKIMKey(pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: pkcs8})); err != nil {
+		t.Errorf("ParseDKIMKey(ed25519 PKCS #8): %v", err)
+	}
+
+	for _, algorithm := range []string{"rsa", "ed25519"} {
+		pemData, err := GenerateDKIMKey(algorithm)
+		if err != nil {
+			t.Fatalf("GenerateDKIMKey(%q): %v", algorithm, err)
+		}
+		if _, err := ParseDKIMKey(pemData); err != nil {
+			t.Errorf("ParseDKIMKey(generated %s): %v", algorithm, err)
+		}
+	}
+
+	if _, err := ParseDKIMKey([]byte("not a key")); err == nil {
+		t.Error("ParseDKIMKey of garbage succeeded")
+	}
+}
@@ -0,0 +1,43 @@
+package mailer
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+var deliveryCounter uint64
+
+// FileTransport drops messages into a maildir (tmp/, new/, cur/ under Dir)
+// so they can be inspected with any mail client instead of being relayed.
+type FileTransport struct {
+	Dir string
+}
+
+func (t *FileTransport) Send(from string, to []string, msg []byte) error {
+	for _, sub := range []string{"tmp", "new", "cur"} {
+		if err := os.MkdirAll(filepath.Join(t.Dir, sub), 0o755); err != nil {
+			return fmt.Errorf("error creating maildir: %v", err)
+		}
+	}
+
+	host, err := os.Hostname()
+	if err != nil {
+		host = "localhost"
+	}
+	name := fmt.Sprintf("%d.M%dP%dQ%d.%s", time.Now().Unix(), time.Now().Nanosecond()/1000, os.Getpid(), atomic.AddUint64(&deliveryCounter, 1), host)
+
+	envelope := fmt.Sprintf("Return-Path: <%s>\r\nX-Original-To: %s\r\n", from, strings.Join(to, ", "))
+	data := append([]byte(envelope), msg...)
+
+	// Maildir delivery: write under tmp/ and rename into new/ so readers
+	// never observe a partially written message.
+	tmpPath := filepath.Join(t.Dir, "tmp", name)
+	if err := os.WriteFile(tmpPath, data, 0o644); err != nil {
+		return fmt.Errorf("error writing message: %v", err)
+	}
+	return os.Rename(tmpPath, filepath.Join(t.Dir, "new", name))
+}
@@ -0,0 +1,47 @@
+package mailer
+
+import (
+	"sync"
+	"time"
+)
+
+// Message is a delivery captured by MemoryTransport.
+type Message struct {
+	From   string
+	To     []string
+	Data   []byte
+	SentAt time.Time
+}
+
+// MemoryTransport records messages instead of sending them. It is safe for
+// concurrent use.
+type MemoryTransport struct {
+	mu       sync.Mutex
+	messages []Message
+}
+
+func (t *MemoryTransport) Send(from string, to []string, msg []byte) error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.messages = append(t.messages, Message{
+		From:   from,
+		To:     append([]string(nil), to...),
+		Data:   append([]byte(nil), msg...),
+		SentAt: time.Now(),
+	})
+	return nil
+}
+
+// Messages returns a copy of everything sent so far.
+func (t *MemoryTransport) Messages() []Message {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return append([]Message(nil), t.messages...)
+}
+
+// Reset discards the recorded messages.
+func (t *MemoryTransport) Reset() {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.messages = nil
+}
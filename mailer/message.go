@@ -0,0 +1,261 @@
+package mailer
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"mime"
+	"mime/multipart"
+	"mime/quotedprintable"
+	"net/mail"
+	"net/textproto"
+	"sort"
+	"strings"
+	"time"
+)
+
+// maxHeaderLine is the length header lines are folded at (RFC 5322
+// recommends 78 characters).
+const maxHeaderLine = 78
+
+// Email is an outgoing message. Bytes renders it as multipart/alternative
+// with a plain-text part (derived from HTML when Text is empty) and the
+// HTML part, both quoted-printable encoded. Inline attachments wrap that in
+// multipart/related and regular ones in multipart/mixed.
+type Email struct {
+	From    mail.Address
+	To      []string
+	ReplyTo string
+	Subject string
+	HTML    string
+	Text    string
+	// Headers are added verbatim, e.g. List-Unsubscribe.
+	Headers map[string]string
+	// Date and MessageID default to now and a random ID at From's domain.
+	Date        time.Time
+	MessageID   string
+	Attachments []Attachment
+	// DKIM, when set, signs the rendered message.
+	DKIM *DKIMSigner
+}
+
+// Attachment is a file sent with an Email. Inline attachments are shown in
+// the HTML where it references them as cid:ContentID.
+type Attachment struct {
+	Filename    string
+	ContentType string
+	ContentID   string
+	Inline      bool
+	Data        []byte
+}
+
+// Bytes renders the message in wire format with CRLF line endings.
+func (m *Email) Bytes() ([]byte, error) {
+	var buf bytes.Buffer
+
+	date := m.Date
+	if date.IsZero() {
+		date = time.Now()
+	}
+	messageID := m.MessageID
+	if messageID == "" {
+		messageID = NewMessageID(m.From.Address)
+	}
+
+	writeHeader(&buf, "From", m.From.String())
+	if len(m.To) > 0 {
+		writeHeader(&buf, "To", strings.Join(m.To, ", "))
+	}
+	if m.ReplyTo != "" {
+		writeHeader(&buf, "Reply-To", m.ReplyTo)
+	}
+	writeHeader(&buf, "Subject", mime.QEncoding.Encode("UTF-8", m.Subject))
+	writeHeader(&buf, "Date", date.Format(time.RFC1123Z))
+	writeHeader(&buf, "Message-ID", messageID)
+
+	keys := make([]string, 0, len(m.Headers))
+	for key := range m.Headers {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		writeHeader(&buf, key, m.Headers[key])
+	}
+	writeHeader(&buf, "MIME-Version", "1.0")
+
+	text := m.Text
+	if text == "" {
+		text = HTMLToText(m.HTML)
+	}
+
+	contentType, body, err := m.body(text)
+	if err != nil {
+		return nil, err
+	}
+	writeHeader(&buf, "Content-Type", contentType)
+	buf.WriteString("\r\n")
+	buf.Write(body)
+
+	if m.DKIM != nil {
+		return m.DKIM.Sign(buf.Bytes())
+	}
+	return buf.Bytes(), nil
+}
+
+// body renders the MIME tree below the top-level headers and returns it
+// with its content type.
+func (m *Email) body(text string) (string, []byte, error) {
+	var inline, attached []Attachment
+	for _, attachment := range m.Attachments {
+		if attachment.Inline {
+			inline = append(inline, attachment)
+		} else {
+			attached = append(attached, attachment)
+		}
+	}
+
+	var alternative bytes.Buffer
+	writer := multipart.NewWriter(&alternative)
+	if err := writeQuotedPrintable(writer, "text/plain; charset=UTF-8", text); err != nil {
+		return "", nil, err
+	}
+	if err := writeQuotedPrintable(writer, "text/html; charset=UTF-8", m.HTML); err != nil {
+		return "", nil, err
+	}
+	if err := writer.Close(); err != nil {
+		return "", nil, err
+	}
+	contentType, body := `multipart/alternative; boundary="`+writer.Boundary()+`"`, alternative.Bytes()
+
+	var err error
+	if len(inline) > 0 {
+		contentType, body, err = wrapMultipart("related", contentType, body, inline)
+		if err != nil {
+			return "", nil, err
+		}
+	}
+	if len(attached) > 0 {
+		contentType, body, err = wrapMultipart("mixed", contentType, body, attached)
+		if err != nil {
+			return "", nil, err
+		}
+	}
+	return contentType, body, nil
+}
+
+// wrapMultipart puts the entity (contentType, body) first in a new
+// multipart/<subtype> followed by the attachments.
+func wrapMultipart(subtype, contentType string, body []byte, attachments []Attachment) (string, []byte, error) {
+	var buf bytes.Buffer
+	writer := multipart.NewWriter(&buf)
+
+	part, err := writer.CreatePart(textproto.MIMEHeader{"Content-Type": {contentType}})
+	if err != nil {
+		return "", nil, err
+	}
+	if _, err := part.Write(body); err != nil {
+		return "", nil, err
+	}
+
+	for _, attachment := range attachments {
+		if err := writeAttachment(writer, attachment); err != nil {
+			return "", nil, err
+		}
+	}
+	if err := writer.Close(); err != nil {
+		return "", nil, err
+	}
+
+	wrapped := "multipart/" + subtype + `; boundary="` + writer.Boundary() + `"`
+	if subtype == "related" {
+		wrapped += `; type="multipart/alternative"`
+	}
+	return wrapped, buf.Bytes(), nil
+}
+
+func writeAttachment(w *multipart.Writer, attachment Attachment) error {
+	contentType := attachment.ContentType
+	if contentType == "" {
+		contentType = "application/octet-stream"
+	}
+	disposition := "attachment"
+	if attachment.Inline {
+		disposition = "inline"
+	}
+
+	header := textproto.MIMEHeader{
+		"Content-Type":              {mime.FormatMediaType(contentType, map[string]string{"name": attachment.Filename})},
+		"Content-Disposition":       {mime.FormatMediaType(disposition, map[string]string{"filename": attachment.Filename})},
+		"Content-Transfer-Encoding": {"base64"},
+	}
+	if header.Get("Content-Type") == "" || header.Get("Content-Disposition") == "" {
+		return fmt.Errorf("mailer: invalid attachment %q (%s)", attachment.Filename, contentType)
+	}
+	if attachment.ContentID != "" {
+		header.Set("Content-ID", "<"+attachment.ContentID+">")
+	}
+
+	part, err := w.CreatePart(header)
+	if err != nil {
+		return err
+	}
+
+	encoded := base64.StdEncoding.EncodeToString(attachment.Data)
+	for len(encoded) > 76 {
+		if _, err := io.WriteString(part, encoded[:76]+"\r\n"); err != nil {
+			return err
+		}
+		encoded = encoded[76:]
+	}
+	_, err = io.WriteString(part, encoded+"\r\n")
+	return err
+}
+
+func writeQuotedPrintable(w *multipart.Writer, contentType, body string) error {
+	part, err := w.CreatePart(textproto.MIMEHeader{
+		"Content-Type":              {contentType},
+		"Content-Transfer-Encoding": {"quoted-printable"},
+	})
+	if err != nil {
+		return err
+	}
+
+	qp := quotedprintable.NewWriter(part)
+	if _, err := qp.Write([]byte(body)); err != nil {
+		return err
+	}
+	return qp.Close()
+}
+
+// writeHeader writes one header field, stripping line breaks from the value
+// and folding it at whitespace to keep lines under maxHeaderLine.
+func writeHeader(buf *bytes.Buffer, key, value string) {
+	value = strings.NewReplacer("\r", " ", "\n", " ").Replace(value)
+
+	line := key + ":"
+	empty := true
+	for _, word := range strings.Split(value, " ") {
+		if len(line)+1+len(word) > maxHeaderLine && !empty {
+			buf.WriteString(line + "\r\n")
+			line = ""
+		}
+		line += " " + word
+		empty = false
+	}
+	buf.WriteString(line + "\r\n")
+}
+
+// NewMessageID returns a unique Message-ID at the domain of addr.
+func NewMessageID(addr string) string {
+	domain := "localhost"
+	if at := strings.LastIndex(addr, "@"); at >= 0 && at < len(addr)-1 {
+		domain = addr[at+1:]
+	}
+
+	b := make([]byte, 16)
+	rand.Read(b)
+	return fmt.Sprintf("<%d.%s@%s>", time.Now().UnixNano(), hex.EncodeToString(b), domain)
+}
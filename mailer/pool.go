@@ -0,0 +1,317 @@
+package mailer
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"net"
+	"net/smtp"
+	"net/textproto"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	dialTimeout = 30 * time.Second
+	// commandTimeout bounds every exchange on a session so a dead
+	// connection can't stall a sender forever.
+	commandTimeout = 5 * time.Minute
+	// healthCheckAfter is how long a connection may sit idle before it is
+	// probed with NOOP on reuse.
+	healthCheckAfter = 10 * time.Second
+)
+
+// PooledSMTPTransport keeps authenticated SMTP sessions open and sends many
+// messages over each of them, issuing RSET in between. When the server
+// advertises PIPELINING the envelope commands are sent in one round trip.
+type PooledSMTPTransport struct {
+	Host     string
+	Port     string
+	Username string
+	Password string
+	// SealedPassword, when set, is used instead of Password; see credential.
+	SealedPassword string
+	// MaxConns caps the number of open sessions (default 1).
+	MaxConns int
+	// IdleTimeout closes sessions unused for that long (default 30s).
+	IdleTimeout time.Duration
+	// MaxMessages recycles a session after that many messages (default 100).
+	MaxMessages int
+	// Dial opens the connections; nil dials directly.
+	Dial DialFunc
+
+	once      sync.Once
+	closeOnce sync.Once
+	done      chan struct{}
+	slots     chan struct{}
+	mu        sync.Mutex
+	idle      []*pooledConn
+}
+
+type pooledConn struct {
+	netConn    net.Conn
+	client     *smtp.Client
+	pipelining bool
+	sent       int
+	lastUsed   time.Time
+}
+
+func (t *PooledSMTPTransport) init() {
+	if t.MaxConns < 1 {
+		t.MaxConns = 1
+	}
+	if t.IdleTimeout <= 0 {
+		t.IdleTimeout = 30 * time.Second
+	}
+	if t.MaxMessages < 1 {
+		t.MaxMessages = 100
+	}
+	t.slots = make(chan struct{}, t.MaxConns)
+	t.done = make(chan struct{})
+	go t.reapIdle()
+}
+
+func (t *PooledSMTPTransport) Send(from string, to []string, msg []byte) error {
+	t.once.Do(t.init)
+
+	t.slots <- struct{}{}
+	defer func() { <-t.slots }()
+
+	conn, err := t.get()
+	if err != nil {
+		return err
+	}
+
+	conn.netConn.SetDeadline(time.Now().Add(commandTimeout))
+	err = conn.deliver(from, to, msg)
+	conn.sent++
+	conn.lastUsed = time.Now()
+
+	// A protocol-level rejection leaves the session in a known state and
+	// RSET will clear it; anything else (I/O errors) means the connection
+	// can no longer be trusted.
+	var reply *textproto.Error
+	if (err == nil || errors.As(err, &reply)) && conn.sent < t.MaxMessages {
+		t.put(conn)
+	} else {
+		conn.close()
+	}
+	return err
+}
+
+// Close shuts down every idle session and stops reaping. The transport is
+// not meant to be used afterwards.
+func (t *PooledSMTPTransport) Close() {
+	t.once.Do(t.init)
+	t.closeOnce.Do(func() { close(t.done) })
+
+	t.mu.Lock()
+	idle := t.idle
+	t.idle = nil
+	t.mu.Unlock()
+
+	for _, conn := range idle {
+		conn.close()
+	}
+}
+
+// get returns a healthy idle session, or dials a new one.
+func (t *PooledSMTPTransport) get() (*pooledConn, error) {
+	for {
+		t.mu.Lock()
+		if len(t.idle) == 0 {
+			t.mu.Unlock()
+			return t.dial()
+		}
+		conn := t.idle[len(t.idle)-1]
+		t.idle = t.idle[:len(t.idle)-1]
+		t.mu.Unlock()
+
+		conn.netConn.SetDeadline(time.Now().Add(commandTimeout))
+		idleFor := time.Since(conn.lastUsed)
+		if idleFor > t.IdleTimeout {
+			conn.close()
+			continue
+		}
+		if idleFor > healthCheckAfter && conn.client.Noop() != nil {
+			conn.close()
+			continue
+		}
+		if conn.client.Reset() != nil {
+			conn.close()
+			continue
+		}
+		return conn, nil
+	}
+}
+
+func (t *PooledSMTPTransport) put(conn *pooledConn) {
+	t.mu.Lock()
+	t.idle = append(t.idle, conn)
+	t.mu.Unlock()
+}
+
+func (t *PooledSMTPTransport) dial() (*pooledConn, error) {
+	return openSession(t.Dial, t.Host, t.Port, t.Username, t.Password, t.SealedPassword)
+}
+
+// openSession connects, upgrades to TLS when offered and authenticates.
+func openSession(dial DialFunc, host, port, username, password, sealedPassword string) (*pooledConn, error) {
+	var netConn net.Conn
+	var err error
+	if dial == nil {
+		netConn, err = net.DialTimeout("tcp", net.JoinHostPort(host, port), dialTimeout)
+	} else {
+		ctx, cancel := context.WithTimeout(context.Background(), dialTimeout)
+		netConn, err = dial(ctx, "tcp", net.JoinHostPort(host, port))
+		cancel()
+	}
+	if err != nil {
+		return nil, err
+	}
+	netConn.SetDeadline(time.Now().Add(commandTimeout))
+
+	client, err := smtp.NewClient(netConn, host)
+	if err != nil {
+		netConn.Close()
+		return nil, err
+	}
+
+	conn := &pooledConn{netConn: netConn, client: client, lastUsed: time.Now()}
+	if ok, _ := client.Extension("STARTTLS"); ok {
+		if err := client.StartTLS(&tls.Config{ServerName: host}); err != nil {
+			conn.close()
+			return nil, err
+		}
+	}
+	if ok, _ := client.Extension("AUTH"); ok && username != "" {
+		password, err := credential(password, sealedPassword)
+		if err != nil {
+			conn.close()
+			return nil, err
+		}
+		if err := client.Auth(smtp.PlainAuth("", username, password, host)); err != nil {
+			conn.close()
+			return nil, err
+		}
+	}
+	conn.pipelining, _ = client.Extension("PIPELINING")
+	return conn, nil
+}
+
+// reapIdle closes sessions that have been idle for longer than IdleTimeout
+// so the relay doesn't have to time them out itself.
+func (t *PooledSMTPTransport) reapIdle() {
+	ticker := time.NewTicker(t.IdleTimeout / 2)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-t.done:
+			return
+		case <-ticker.C:
+		}
+
+		var expired []*pooledConn
+
+		t.mu.Lock()
+		kept := t.idle[:0]
+		for _, conn := range t.idle {
+			if time.Since(conn.lastUsed) > t.IdleTimeout {
+				expired = append(expired, conn)
+			} else {
+				kept = append(kept, conn)
+			}
+		}
+		t.idle = kept
+		t.mu.Unlock()
+
+		for _, conn := range expired {
+			conn.close()
+		}
+	}
+}
+
+func (c *pooledConn) deliver(from string, to []string, msg []byte) error {
+	for _, addr := range append([]string{from}, to...) {
+		if strings.ContainsAny(addr, "\r\n") {
+			return errors.New("smtp: address contains CR or LF")
+		}
+	}
+	if c.pipelining {
+		return c.deliverPipelined(from, to, msg)
+	}
+
+	if err := c.client.Mail(from); err != nil {
+		return err
+	}
+	for _, rcpt := range to {
+		if err := c.client.Rcpt(rcpt); err != nil {
+			return err
+		}
+	}
+	w, err := c.client.Data()
+	if err != nil {
+		return err
+	}
+	if _, err := w.Write(msg); err != nil {
+		return err
+	}
+	return w.Close()
+}
+
+// deliverPipelined sends MAIL, every RCPT and DATA back to back (RFC 2920)
+// and only then reads the replies, in order.
+func (c *pooledConn) deliverPipelined(from string, to []string, msg []byte) error {
+	text := c.client.Text
+
+	if err := text.PrintfLine("MAIL FROM:<%s>", from); err != nil {
+		return err
+	}
+	for _, rcpt := range to {
+		if err := text.PrintfLine("RCPT TO:<%s>", rcpt); err != nil {
+			return err
+		}
+	}
+	if err := text.PrintfLine("DATA"); err != nil {
+		return err
+	}
+
+	// Every reply has to be consumed to keep the session in sync, even
+	// after the first rejection.
+	_, _, firstErr := text.ReadResponse(250)
+	for range to {
+		if _, _, err := text.ReadResponse(25); err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+	_, _, dataErr := text.ReadResponse(354)
+	if dataErr != nil {
+		if firstErr != nil {
+			return firstErr
+		}
+		return dataErr
+	}
+
+	// DATA was accepted, so at least one recipient is valid: deliver to
+	// those and still report the rejected ones.
+	w := text.DotWriter()
+	if _, err := w.Write(msg); err != nil {
+		return err
+	}
+	if err := w.Close(); err != nil {
+		return err
+	}
+	if _, _, err := text.ReadResponse(250); err != nil && firstErr == nil {
+		firstErr = err
+	}
+	return firstErr
+}
+
+func (c *pooledConn) close() {
+	c.netConn.SetDeadline(time.Now().Add(dialTimeout))
+	if err := c.client.Quit(); err != nil {
+		c.client.Close()
+	}
+}
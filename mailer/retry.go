@@ -0,0 +1,113 @@
+package mailer
+
+import (
+	"errors"
+	"io"
+	"math/rand"
+	"net"
+	"net/textproto"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/karan-singh-17/Quick-Mail/fetcher"
+)
+
+// IsTransient reports whether a failed send is worth retrying. SMTP 4xx
+// replies (greylisting, temporary rate limits) and network failures are
+// transient; 5xx replies and anything else are treated as permanent, and
+// so is a server address the dialer refused.
+func IsTransient(err error) bool {
+	var reply *textproto.Error
+	if errors.As(err, &reply) {
+		return reply.Code >= 400 && reply.Code < 500
+	}
+
+	if errors.Is(err, fetcher.ErrBlocked) {
+		return false
+	}
+	var netErr net.Error
+	if errors.As(err, &netErr) {
+		return true
+	}
+	return errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF)
+}
+
+// IsHardBounce reports whether err means the mailbox does not exist, so the
+// address should not be mailed again: a 550/551/553 reply carrying a 5.1.x
+// enhanced status code, or a bare 550/551 without one.
+func IsHardBounce(err error) bool {
+	var reply *textproto.Error
+	if !errors.As(err, &reply) {
+		return false
+	}
+	switch reply.Code {
+	case 550, 551, 553:
+	default:
+		return false
+	}
+
+	msg := strings.TrimSpace(reply.Msg)
+	if len(msg) > 1 && msg[0] >= '2' && msg[0] <= '5' && msg[1] == '.' {
+		return strings.HasPrefix(msg, "5.1.")
+	}
+	return reply.Code != 553
+}
+
+// RetryPolicy retries transient failures with jittered exponential backoff.
+type RetryPolicy struct {
+	// MaxRetries is the number of retries after the first attempt.
+	MaxRetries int
+	BaseDelay  time.Duration
+	MaxDelay   time.Duration
+}
+
+// RetryPolicyFromEnv reads smtpMaxRetries (default 4), smtpRetryBaseDelay
+// (default 30s) and smtpRetryMaxDelay (default 10m).
+func RetryPolicyFromEnv() RetryPolicy {
+	policy := RetryPolicy{MaxRetries: 4, BaseDelay: 30 * time.Second, MaxDelay: 10 * time.Minute}
+	if n, err := strconv.Atoi(os.Getenv("smtpMaxRetries")); err == nil && n >= 0 {
+		policy.MaxRetries = n
+	}
+	if d, err := time.ParseDuration(os.Getenv("smtpRetryBaseDelay")); err == nil && d > 0 {
+		policy.BaseDelay = d
+	}
+	if d, err := time.ParseDuration(os.Getenv("smtpRetryMaxDelay")); err == nil && d > 0 {
+		policy.MaxDelay = d
+	}
+	return policy
+}
+
+// Backoff returns how long to wait before the given retry (starting at 1).
+// It uses "full jitter": a random delay up to the capped exponential value,
+// so retries of a whole campaign don't hit the relay in lockstep.
+func (p RetryPolicy) Backoff(retry int) time.Duration {
+	ceiling := p.MaxDelay
+	if retry < 1 {
+		retry = 1
+	}
+	if retry <= 30 {
+		if d := p.BaseDelay << (retry - 1); d > 0 && d < ceiling {
+			ceiling = d
+		}
+	}
+	if ceiling <= 0 {
+		return 0
+	}
+	return time.Duration(rand.Int63n(int64(ceiling) + 1))
+}
+
+// Send delivers msg through t, retrying transient failures. It returns the
+// number of attempts made and the error of the last one.
+func (p RetryPolicy) Send(t Transport, from string, to []string, msg []byte) (int, error) {
+	attempts := 0
+	for {
+		attempts++
+		err := t.Send(from, to, msg)
+		if err == nil || !IsTransient(err) || attempts > p.MaxRetries {
+			return attempts, err
+		}
+		time.Sleep(p.Backoff(attempts))
+	}
+}
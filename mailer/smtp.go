@@ -0,0 +1,59 @@
+package mailer
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/smtp"
+
+	"github.com/karan-singh-17/Quick-Mail/vault"
+)
+
+// SMTPTransport sends every message over a fresh authenticated SMTP session.
+type SMTPTransport struct {
+	Host     string
+	Port     string
+	Username string
+	Password string
+	// SealedPassword, when set, is used instead of Password; see credential.
+	SealedPassword string
+	// Dial opens the connection; nil dials directly.
+	Dial DialFunc
+}
+
+// DialFunc opens the connection to an SMTP server, e.g. through a dialer
+// that refuses internal addresses for servers given by users.
+type DialFunc func(ctx context.Context, network, addr string) (net.Conn, error)
+
+func (t *SMTPTransport) Send(from string, to []string, msg []byte) error {
+	if t.Dial == nil {
+		password, err := credential(t.Password, t.SealedPassword)
+		if err != nil {
+			return err
+		}
+		auth := smtp.PlainAuth("", t.Username, password, t.Host)
+		addr := fmt.Sprintf("%s:%s", t.Host, t.Port)
+		return smtp.SendMail(addr, auth, from, to, msg)
+	}
+
+	conn, err := openSession(t.Dial, t.Host, t.Port, t.Username, t.Password, t.SealedPassword)
+	if err != nil {
+		return err
+	}
+	defer conn.close()
+	return conn.deliver(from, to, msg)
+}
+
+// credential returns the password to authenticate with. A vault-sealed
+// password is only opened here, right before authenticating, so it is never
+// kept in plaintext anywhere else.
+func credential(password, sealed string) (string, error) {
+	if sealed == "" {
+		return password, nil
+	}
+	if !vault.IsSealed(sealed) {
+		return "", errors.New("smtp: stored password is not encrypted, the server needs vaultMasterKeys")
+	}
+	return vault.Open(sealed)
+}
@@ -0,0 +1,133 @@
+package mailer
+
+import (
+	"fmt"
+	"strings"
+
+	"golang.org/x/net/html"
+)
+
+// blockTags start a new line in the plain-text rendering.
+var blockTags = map[string]bool{
+	"p": true, "div": true, "br": true, "tr": true, "table": true,
+	"h1": true, "h2": true, "h3": true, "h4": true, "h5": true, "h6": true,
+	"ul": true, "ol": true, "li": true, "blockquote": true, "hr": true,
+	"section": true, "header": true, "footer": true, "article": true,
+}
+
+// skipTags have content that never belongs in the plain-text rendering.
+var skipTags = map[string]bool{"head": true, "title": true, "script": true, "style": true}
+
+// HTMLToText renders an HTML body as plain text. Block elements become line
+// breaks, list items are bulleted and links are kept as numbered footnotes
+// ("Click here [1]" ... "[1] https://...").
+func HTMLToText(body string) string {
+	var out strings.Builder
+	var links []string
+	skip := 0
+	inLink := false
+	// space records whitespace seen between two runs of text.
+	space := false
+
+	tokenizer := html.NewTokenizer(strings.NewReader(body))
+	for {
+		switch tokenizer.Next() {
+		case html.ErrorToken:
+			return finishText(out.String(), links)
+
+		case html.TextToken:
+			if skip > 0 {
+				continue
+			}
+			raw := tokenizer.Raw()
+			text := strings.Join(strings.Fields(string(tokenizer.Text())), " ")
+			if text == "" {
+				space = space || len(raw) > 0
+				continue
+			}
+			if space || isSpace(raw[0]) {
+				out.WriteString(" ")
+			}
+			out.WriteString(text)
+			space = isSpace(raw[len(raw)-1])
+
+		case html.StartTagToken, html.SelfClosingTagToken:
+			token := tokenizer.Token()
+			if skipTags[token.Data] {
+				if token.Type == html.StartTagToken {
+					skip++
+				}
+				continue
+			}
+			if blockTags[token.Data] {
+				out.WriteString("\n")
+				space = false
+			}
+			if token.Data == "li" {
+				out.WriteString("- ")
+			}
+			if token.Data == "a" {
+				for _, attr := range token.Attr {
+					if attr.Key == "href" && isLink(attr.Val) {
+						links = append(links, attr.Val)
+						inLink = true
+					}
+				}
+			}
+
+		case html.EndTagToken:
+			token := tokenizer.Token()
+			if skipTags[token.Data] {
+				if skip > 0 {
+					skip--
+				}
+				continue
+			}
+			if token.Data == "a" && inLink {
+				out.WriteString(fmt.Sprintf(" [%d]", len(links)))
+				inLink = false
+			}
+			if blockTags[token.Data] && token.Data != "li" {
+				out.WriteString("\n")
+				space = false
+			}
+		}
+	}
+}
+
+// finishText trims trailing spaces, collapses runs of blank lines and
+// appends the link footnotes.
+func finishText(text string, links []string) string {
+	var lines []string
+	blank := true
+	for _, line := range strings.Split(text, "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" {
+			if !blank {
+				lines = append(lines, "")
+			}
+			blank = true
+			continue
+		}
+		lines = append(lines, line)
+		blank = false
+	}
+
+	result := strings.TrimSpace(strings.Join(lines, "\n"))
+	if len(links) > 0 {
+		result += "\n\nLinks:\n"
+		for i, link := range links {
+			result += fmt.Sprintf("[%d] %s\n", i+1, link)
+		}
+	}
+	return result
+}
+
+func isLink(href string) bool {
+	href = strings.ToLower(strings.TrimSpace(href))
+	return strings.HasPrefix(href, "http://") || strings.HasPrefix(href, "https://") || strings.HasPrefix(href, "mailto:")
+}
+
+func isSpace(b byte) bool {
+	return b == ' ' || b == '\n' || b == '\t' || b == '\r'
+}
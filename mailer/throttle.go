@@ -0,0 +1,171 @@
+package mailer
+
+import (
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// RateLimiter is a token bucket releasing rate tokens per second, up to
+// burst at once. A nil *RateLimiter never blocks.
+type RateLimiter struct {
+	mu     sync.Mutex
+	rate   float64
+	burst  float64
+	tokens float64
+	last   time.Time
+}
+
+// NewRateLimiter returns nil (unlimited) when perSecond is not positive.
+func NewRateLimiter(perSecond float64, burst int) *RateLimiter {
+	if perSecond <= 0 {
+		return nil
+	}
+	if burst < 1 {
+		burst = 1
+	}
+	return &RateLimiter{rate: perSecond, burst: float64(burst), tokens: float64(burst), last: time.Now()}
+}
+
+// Wait blocks until a token is available and takes it.
+func (l *RateLimiter) Wait() {
+	if l == nil {
+		return
+	}
+
+	l.mu.Lock()
+	now := time.Now()
+	l.tokens += now.Sub(l.last).Seconds() * l.rate
+	if l.tokens > l.burst {
+		l.tokens = l.burst
+	}
+	l.last = now
+
+	// Taking the token up front (possibly going negative) queues callers
+	// in arrival order without holding the lock while they sleep.
+	l.tokens--
+	var wait time.Duration
+	if l.tokens < 0 {
+		wait = time.Duration(-l.tokens / l.rate * float64(time.Second))
+	}
+	l.mu.Unlock()
+
+	time.Sleep(wait)
+}
+
+// Throttle bounds outgoing traffic: a global messages-per-second limit, a
+// per sending account limit and a cap on concurrent sends to any single
+// recipient domain.
+type Throttle struct {
+	global      *RateLimiter
+	accountRate float64
+	domainLimit int
+
+	mu       sync.Mutex
+	accounts map[string]*RateLimiter
+	domains  map[string]chan struct{}
+}
+
+// NewThrottle builds a throttle; zero values disable the matching limit.
+func NewThrottle(globalRate, accountRate float64, domainLimit int) *Throttle {
+	return &Throttle{
+		global:      NewRateLimiter(globalRate, int(globalRate)),
+		accountRate: accountRate,
+		domainLimit: domainLimit,
+		accounts:    make(map[string]*RateLimiter),
+		domains:     make(map[string]chan struct{}),
+	}
+}
+
+// ThrottleFromEnv reads sendRatePerSecond, sendRatePerAccount and
+// sendDomainConcurrency; all of them default to unlimited.
+func ThrottleFromEnv() *Throttle {
+	globalRate, _ := strconv.ParseFloat(os.Getenv("sendRatePerSecond"), 64)
+	accountRate, _ := strconv.ParseFloat(os.Getenv("sendRatePerAccount"), 64)
+	domainLimit, _ := strconv.Atoi(os.Getenv("sendDomainConcurrency"))
+	return NewThrottle(globalRate, accountRate, domainLimit)
+}
+
+// Acquire waits until a message from account to recipient may be sent. The
+// returned function must be called once the send has finished.
+func (t *Throttle) Acquire(account, recipient string) (release func()) {
+	slot := t.domainSlot(recipient)
+	if slot != nil {
+		slot <- struct{}{}
+	}
+
+	t.accountLimiter(account).Wait()
+	t.global.Wait()
+
+	return func() {
+		if slot != nil {
+			<-slot
+		}
+	}
+}
+
+// Rate is the most messages per second one account can send, the lower of
+// the global and per-account limits, or 0 when neither is set.
+func (t *Throttle) Rate() float64 {
+	rate := t.accountRate
+	if t.global != nil && (rate <= 0 || t.global.rate < rate) {
+		rate = t.global.rate
+	}
+	if rate < 0 {
+		return 0
+	}
+	return rate
+}
+
+// Wrap returns a transport that goes through the throttle for every send,
+// including retries.
+func (t *Throttle) Wrap(next Transport) Transport {
+	return &throttledTransport{throttle: t, next: next}
+}
+
+func (t *Throttle) accountLimiter(account string) *RateLimiter {
+	if t.accountRate <= 0 {
+		return nil
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	limiter, ok := t.accounts[account]
+	if !ok {
+		limiter = NewRateLimiter(t.accountRate, int(t.accountRate))
+		t.accounts[account] = limiter
+	}
+	return limiter
+}
+
+func (t *Throttle) domainSlot(recipient string) chan struct{} {
+	if t.domainLimit <= 0 {
+		return nil
+	}
+	domain := strings.ToLower(recipient[strings.LastIndex(recipient, "@")+1:])
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	slot, ok := t.domains[domain]
+	if !ok {
+		slot = make(chan struct{}, t.domainLimit)
+		t.domains[domain] = slot
+	}
+	return slot
+}
+
+type throttledTransport struct {
+	throttle *Throttle
+	next     Transport
+}
+
+func (t *throttledTransport) Send(from string, to []string, msg []byte) error {
+	recipient := ""
+	if len(to) > 0 {
+		recipient = to[0]
+	}
+	release := t.throttle.Acquire(from, recipient)
+	defer release()
+	return t.next.Send(from, to, msg)
+}
@@ -0,0 +1,71 @@
+// Package mailer hands fully assembled messages to a delivery transport.
+// The transport used by the server is chosen through the mailTransport
+// environment variable so staging and test environments can exercise the
+// whole send path without a real relay.
+package mailer
+
+import (
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Transport delivers a raw RFC 5322 message to the given envelope recipients.
+type Transport interface {
+	Send(from string, to []string, msg []byte) error
+}
+
+// NewFromEnv builds the transport selected by the mailTransport variable:
+//   - "smtp" (default) authenticates against smtpHost:smtpPort as from/password,
+//     keeping up to smtpPoolSize sessions open (0 opens one per message)
+//   - "file" writes every message into the maildir at mailDir
+//   - "memory" keeps messages in process, see MemoryTransport
+func NewFromEnv() Transport {
+	switch strings.ToLower(strings.TrimSpace(os.Getenv("mailTransport"))) {
+	case "file", "maildir":
+		dir := os.Getenv("mailDir")
+		if dir == "" {
+			dir = "maildir"
+		}
+		return &FileTransport{Dir: dir}
+	case "memory":
+		return &MemoryTransport{}
+	default:
+		return NewSMTP(os.Getenv("smtpHost"), os.Getenv("smtpPort"), os.Getenv("from"), os.Getenv("password"))
+	}
+}
+
+// NewSMTP builds an SMTP transport authenticating as username, pooled as
+// configured by smtpPoolSize and smtpIdleTimeout.
+func NewSMTP(host, port, username, password string) Transport {
+	return newSMTP(host, port, username, password, "", nil)
+}
+
+// NewSealedSMTP is NewSMTP for a password sealed by the vault. It is only
+// opened when a session authenticates. Connections are opened with dial,
+// which for servers given by users should refuse internal addresses.
+func NewSealedSMTP(host, port, username, sealedPassword string, dial DialFunc) Transport {
+	return newSMTP(host, port, username, "", sealedPassword, dial)
+}
+
+func newSMTP(host, port, username, password, sealedPassword string, dial DialFunc) Transport {
+	poolSize := 5
+	if n, err := strconv.Atoi(os.Getenv("smtpPoolSize")); err == nil && n >= 0 {
+		poolSize = n
+	}
+	if poolSize == 0 {
+		return &SMTPTransport{Host: host, Port: port, Username: username, Password: password, SealedPassword: sealedPassword, Dial: dial}
+	}
+	idleTimeout, _ := time.ParseDuration(os.Getenv("smtpIdleTimeout"))
+	return &PooledSMTPTransport{
+		Host:           host,
+		Port:           port,
+		Username:       username,
+		Password:       password,
+		SealedPassword: sealedPassword,
+		MaxConns:       poolSize,
+		IdleTimeout:    idleTimeout,
+		Dial:           dial,
+	}
+}
@@ -3,9 +3,10 @@ package main
 import (
 	"log"
 	"net/http"
+	_ "time/tzdata"
 
-	"github.com/joho/godotenv"
 	"github.com/karan-singh-17/Quick-Mail/database"
+	"github.com/karan-singh-17/Quick-Mail/handlers"
 	"github.com/karan-singh-17/Quick-Mail/routes"
 	"github.com/rs/cors"
 )
@@ -30,7 +31,13 @@ func main() {
 	//	log.Fatalf("Error loading .env file")
 	//}
 
+	if err := handlers.CheckConfig(); err != nil {
+		log.Fatal(err)
+	}
+
 	database.Connect()
+	handlers.StartCampaignWorkers()
+	handlers.StartRecurringScheduler()
 
 	mux := http.NewServeMux()
 	routes.SetupRoutes(mux)
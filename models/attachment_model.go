@@ -0,0 +1,19 @@
+package models
+
+import "time"
+
+// Attachment is a file sent with every mail of a group. Inline attachments
+// are images the message references as <img src="cid:ContentID">; the
+// others are attached as regular files.
+type Attachment struct {
+	ID          uint      `gorm:"primaryKey" json:"id"`
+	Group_ID    string    `gorm:"size:191;index" json:"group_id"`
+	Filename    string    `json:"filename"`
+	ContentType string    `json:"content_type"`
+	Size        int64     `json:"size"`
+	Inline      bool      `json:"inline"`
+	ContentID   string    `gorm:"size:191" json:"content_id,omitempty"`
+	SourceURL   string    `json:"source_url,omitempty"`
+	Data        []byte    `gorm:"type:longblob" json:"-"`
+	CreatedAt   time.Time `json:"created_at"`
+}
@@ -0,0 +1,37 @@
+package models
+
+import "time"
+
+const (
+	CampaignScheduled = "scheduled"
+	CampaignQueued    = "queued"
+	CampaignRunning   = "running"
+	CampaignCompleted = "completed"
+	CampaignFailed    = "failed"
+	CampaignCancelled = "cancelled"
+)
+
+const (
+	TriggerManual = "manual"
+	TriggerCron   = "cron"
+)
+
+// Campaign is a single execution of a group. It doubles as the durable job
+// picked up by the send workers: a worker holds a lease on a running
+// campaign, and once the lease runs out (e.g. after a restart) another
+// worker takes the campaign over. Scheduled campaigns wait in the same
+// table until ScheduledAt has passed.
+type Campaign struct {
+	Campaign_ID string     `gorm:"primaryKey" json:"campaign_id"`
+	Group_ID    string     `gorm:"index" json:"group_id"`
+	Owner_ID    string     `gorm:"index" json:"owner_id"`
+	Status      string     `gorm:"index" json:"status"`
+	Trigger     string     `gorm:"size:16" json:"trigger"`
+	Error       string     `json:"error,omitempty"`
+	ScheduledAt *time.Time `gorm:"index" json:"scheduled_at,omitempty"`
+	TimeZone    string     `json:"time_zone,omitempty"`
+	LeaseUntil  *time.Time `json:"-"`
+	CreatedAt   time.Time  `json:"created_at"`
+	StartedAt   *time.Time `json:"started_at,omitempty"`
+	FinishedAt  *time.Time `json:"finished_at,omitempty"`
+}
@@ -0,0 +1,28 @@
+package models
+
+import "time"
+
+const (
+	DeliveryPending = "pending"
+	// DeliverySending marks a recipient handed to a send worker. A campaign
+	// interrupted at that point can't tell whether the mail went out, so
+	// such rows are failed on resume instead of being sent again.
+	DeliverySending = "sending"
+	DeliverySent    = "sent"
+	DeliveryFailed  = "failed"
+	// DeliverySkipped marks suppressed recipients, which are never sent to.
+	DeliverySkipped = "skipped"
+)
+
+// Delivery tracks one recipient of a campaign. Rows are created as pending
+// when a campaign starts, which is also what lets an interrupted campaign
+// resume without mailing anyone twice.
+type Delivery struct {
+	ID          uint       `gorm:"primaryKey" json:"-"`
+	Campaign_ID string     `gorm:"size:64;uniqueIndex:idx_delivery_campaign_recipient" json:"campaign_id"`
+	Recipient   string     `gorm:"size:191;uniqueIndex:idx_delivery_campaign_recipient" json:"recipient"`
+	Status      string     `gorm:"size:16;index" json:"status"`
+	Attempts    int        `json:"attempts"`
+	Response    string     `json:"response,omitempty"`
+	AttemptedAt *time.Time `json:"attempted_at,omitempty"`
+}
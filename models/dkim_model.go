@@ -0,0 +1,18 @@
+package models
+
+import "time"
+
+// DKIMKey is a user's signing key for one sender domain. Mail from an
+// address at Domain is signed with it under Selector, as long as the user
+// has a verified sender at Domain. PrivateKey is sealed by the vault and
+// only opened to sign; Record is the public DNS value derived from it.
+type DKIMKey struct {
+	ID         uint      `gorm:"primaryKey" json:"id"`
+	Owner_ID   string    `gorm:"size:191;uniqueIndex:idx_dkim_owner_domain" json:"owner_id"`
+	Domain     string    `gorm:"size:191;uniqueIndex:idx_dkim_owner_domain" json:"domain"`
+	Selector   string    `gorm:"size:63" json:"selector"`
+	Algorithm  string    `gorm:"size:16" json:"algorithm"`
+	PrivateKey string    `gorm:"type:text" json:"-"`
+	Record     string    `gorm:"type:text" json:"-"`
+	CreatedAt  time.Time `json:"created_at"`
+}
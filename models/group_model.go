@@ -1,10 +1,55 @@
 package models
 
+import "time"
+
 type Group struct {
-	Group_ID   string `gorm:"primaryKey" json:"group_id"`
-	Name       string `json:"name"`
-	Owner_ID   string `json:"owner_id"`
-	Recipients string `json:"recipients"`
-	Subject    string `json:"subject"`
-	Message    string `json:"message"`
+	Group_ID string `gorm:"primaryKey" json:"group_id"`
+	Name     string `json:"name"`
+	Owner_ID string `json:"owner_id"`
+	Subject  string `json:"subject"`
+	Message  string `json:"message"`
+	// Sender_ID picks the verified sender identity the group is mailed
+	// from; empty means the server's own address.
+	Sender_ID string `json:"sender_id,omitempty"`
+	// CSVLink and HTMLLink remember where the recipients and message were
+	// fetched from so they can be pulled again at send time.
+	CSVLink  string `json:"csv_link,omitempty"`
+	HTMLLink string `json:"html_link,omitempty"`
+	// LiveSources fetches the links again for every execution instead of
+	// only for recurring runs.
+	LiveSources bool `json:"live_sources"`
+	// ImportOptions pick the sheet and columns recipients are read from,
+	// for imports and every later refresh of CSVLink.
+	ImportOptions ImportOptions `gorm:"type:text;serializer:json" json:"import_options"`
+	// MergePlusAddresses treats plus-addressing variants (jane+news@x.com)
+	// as the same recipient as the plain address when importing.
+	MergePlusAddresses bool `json:"merge_plus_addresses"`
+	// PlainMessage sends the subject and message as they are, without
+	// merge fields, for content with {{...}} placeholders of its own.
+	PlainMessage bool `gorm:"not null;default:false" json:"plain_message"`
+	// Cron, when set, makes the group send itself on that schedule in
+	// CronTimeZone until CronEndAt. NextRunAt is the upcoming occurrence.
+	Cron         string     `json:"cron,omitempty"`
+	CronTimeZone string     `json:"cron_time_zone,omitempty"`
+	CronEndAt    *time.Time `json:"cron_end_at,omitempty"`
+	NextRunAt    *time.Time `gorm:"index" json:"next_run_at,omitempty"`
+	// RecipientCount is filled in by the handlers; the members themselves
+	// live in the recipients table.
+	RecipientCount int64 `gorm:"-" json:"recipient_count"`
+}
+
+// ImportOptions map a recipient list onto recipients. Sheet picks the sheet
+// of an XLSX or ODS workbook by name or 1-based position (default the
+// first). Delimiter and Encoding override what is sniffed from a CSV, and
+// Header says whether the first row names the columns; without one the
+// columns are called "Column 1", "Column 2", ... EmailColumn names the
+// address column and Columns maps other columns to merge fields; with
+// Columns set, unlisted columns are left out.
+type ImportOptions struct {
+	Sheet       string            `json:"sheet,omitempty"`
+	Delimiter   string            `json:"delimiter,omitempty"`
+	Encoding    string            `json:"encoding,omitempty"`
+	Header      *bool             `json:"header,omitempty"`
+	EmailColumn string            `json:"email_column,omitempty"`
+	Columns     map[string]string `json:"columns,omitempty"`
 }
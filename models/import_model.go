@@ -0,0 +1,18 @@
+package models
+
+import "time"
+
+// PendingImport is a recipient list read by an import preview. It is kept
+// until the import is committed or ExpiresAt passes, so committing doesn't
+// need the list to be uploaded or fetched again.
+type PendingImport struct {
+	Import_ID string        `gorm:"size:191;primaryKey" json:"import_id"`
+	Owner_ID  string        `gorm:"size:191;index" json:"owner_id"`
+	Group_ID  string        `gorm:"size:191;index" json:"group_id"`
+	Link      string        `json:"link,omitempty"`
+	Options   ImportOptions `gorm:"type:text;serializer:json" json:"import_options"`
+	Size      int64         `json:"size"`
+	Data      []byte        `gorm:"type:longblob" json:"-"`
+	ExpiresAt time.Time     `gorm:"index" json:"expires_at"`
+	CreatedAt time.Time     `json:"created_at"`
+}
@@ -0,0 +1,25 @@
+package models
+
+import "time"
+
+const RecipientActive = "active"
+
+// RecipientSourceCSV marks recipients that came from the group's CSV link;
+// they are replaced whenever the link is fetched again.
+const RecipientSourceCSV = "csv"
+
+// Recipient is a member of a group, along with the merge fields imported
+// for it (see handlers.readRecipients). EmailKey and PlusKey are the
+// handlers.recipientKey of Email without and with plus-address merging, so
+// addresses can be matched by an indexed lookup.
+type Recipient struct {
+	ID         uint              `gorm:"primaryKey" json:"id"`
+	Group_ID   string            `gorm:"size:191;uniqueIndex:idx_recipient_group_email;index:idx_recipient_email_key;index:idx_recipient_plus_key" json:"group_id"`
+	Email      string            `gorm:"size:191;uniqueIndex:idx_recipient_group_email;index" json:"email"`
+	EmailKey   string            `gorm:"size:191;not null;default:'';index:idx_recipient_email_key" json:"-"`
+	PlusKey    string            `gorm:"size:191;not null;default:'';index:idx_recipient_plus_key" json:"-"`
+	Attributes map[string]string `gorm:"type:text;serializer:json" json:"attributes,omitempty"`
+	Status     string            `gorm:"size:16;index" json:"status"`
+	Source     string            `gorm:"size:16" json:"source,omitempty"`
+	AddedAt    time.Time         `json:"added_at"`
+}
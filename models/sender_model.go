@@ -0,0 +1,27 @@
+package models
+
+import "time"
+
+// SenderIdentity is an address a user may send group mails from. It can
+// only be used once the address confirmed it through VerifyToken, before
+// VerifyExpiresAt. When
+// SMTPHost is set, mail from the identity goes through that server with its
+// own credentials instead of the server's. SMTPPassword is sealed by the
+// vault and only opened by the mailer while authenticating.
+type SenderIdentity struct {
+	Sender_ID    string `gorm:"primaryKey;size:64" json:"sender_id"`
+	Owner_ID     string `gorm:"size:191;index" json:"owner_id"`
+	DisplayName  string `json:"display_name"`
+	Email        string `gorm:"size:191" json:"email"`
+	ReplyTo      string `json:"reply_to,omitempty"`
+	SMTPHost     string `json:"smtp_host,omitempty"`
+	SMTPPort     string `json:"smtp_port,omitempty"`
+	SMTPUsername string `json:"smtp_username,omitempty"`
+	SMTPPassword string `json:"-"`
+	Verified     bool   `json:"verified"`
+	VerifyToken  string `gorm:"size:64;index" json:"-"`
+	// VerifyExpiresAt is when VerifyToken stops being accepted.
+	VerifyExpiresAt *time.Time `json:"verify_expires_at,omitempty"`
+	CreatedAt       time.Time  `json:"created_at"`
+	VerifiedAt      *time.Time `json:"verified_at,omitempty"`
+}
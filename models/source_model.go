@@ -0,0 +1,25 @@
+package models
+
+import "time"
+
+const (
+	SourceCSV  = "csv"
+	SourceHTML = "html"
+)
+
+// SourceCache remembers the last successful fetch of a group's CSV or HTML
+// link. The validators let the next fetch be a conditional request, and
+// the content itself stays in the group (recipients, message) so it serves
+// as the fallback when the link can't be fetched. OptionsHash identifies
+// the import options a recipient list was read with, so the list is read
+// again when they change even if the link didn't.
+type SourceCache struct {
+	Group_ID     string    `gorm:"size:191;primaryKey" json:"group_id"`
+	Kind         string    `gorm:"size:8;primaryKey" json:"kind"`
+	URL          string    `json:"url"`
+	ETag         string    `json:"etag,omitempty"`
+	LastModified string    `json:"last_modified,omitempty"`
+	ContentHash  string    `gorm:"size:64" json:"-"`
+	OptionsHash  string    `gorm:"size:64" json:"-"`
+	FetchedAt    time.Time `json:"fetched_at"`
+}
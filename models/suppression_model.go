@@ -0,0 +1,23 @@
+package models
+
+import "time"
+
+const (
+	SuppressionUnsubscribe = "unsubscribe"
+	SuppressionBounce      = "bounce"
+	SuppressionComplaint   = "complaint"
+	SuppressionManual      = "manual"
+)
+
+// Suppression keeps an address from being mailed. Entries with an empty
+// Owner_ID are global (hard bounces on the server's own transport,
+// complaints) and apply to every user; the others only cover the groups of
+// that owner.
+type Suppression struct {
+	ID        uint      `gorm:"primaryKey" json:"-"`
+	Owner_ID  string    `gorm:"size:191;uniqueIndex:idx_suppression_owner_email" json:"owner_id,omitempty"`
+	Email     string    `gorm:"size:191;uniqueIndex:idx_suppression_owner_email;index" json:"email"`
+	Reason    string    `gorm:"size:32" json:"reason"`
+	Group_ID  string    `gorm:"size:191" json:"group_id,omitempty"`
+	CreatedAt time.Time `json:"created_at"`
+}
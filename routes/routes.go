@@ -17,6 +17,16 @@ func SetupRoutes(mux *http.ServeMux) {
 	mux.HandleFunc("/api/user/login", handlers.Login)
 	mux.HandleFunc("/api/user/logout", handlers.LogOut)
 	mux.HandleFunc("/api/user/verify-login-code", handlers.VerifyLoginCode)
+	mux.HandleFunc("/api/unsubscribe", handlers.Unsubscribe)
+	mux.Handle("/api/suppressions", middleware.AuthMiddleware(http.HandlerFunc(handlers.Suppressions)))
+	mux.HandleFunc("GET /api/senders/verify/{token}", handlers.VerifySender)
+	mux.Handle("GET /api/senders", middleware.AuthMiddleware(http.HandlerFunc(handlers.ListSenders)))
+	mux.Handle("POST /api/senders", middleware.AuthMiddleware(http.HandlerFunc(handlers.AddSender)))
+	mux.Handle("DELETE /api/senders/{id}", middleware.AuthMiddleware(http.HandlerFunc(handlers.DeleteSender)))
+	mux.Handle("GET /api/dkim/keys", middleware.AuthMiddleware(http.HandlerFunc(handlers.ListDKIMKeys)))
+	mux.Handle("POST /api/dkim/keys", middleware.AuthMiddleware(http.HandlerFunc(handlers.AddDKIMKey)))
+	mux.Handle("GET /api/dkim/keys/{id}/dns", middleware.AuthMiddleware(http.HandlerFunc(handlers.GetDKIMRecord)))
+	mux.Handle("DELETE /api/dkim/keys/{id}", middleware.AuthMiddleware(http.HandlerFunc(handlers.DeleteDKIMKey)))
 	mux.Handle("/api/user/curr-user", middleware.AuthMiddleware(http.HandlerFunc(handlers.CurrentUser)))
 
 	mux.Handle("/api/group/create-group", middleware.AuthMiddleware(http.HandlerFunc(handlers.CreateGroup)))
@@ -24,4 +34,19 @@ func SetupRoutes(mux *http.ServeMux) {
 	mux.Handle("/api/group/execute-group", middleware.AuthMiddleware(http.HandlerFunc(handlers.SendMailToGroup)))
 	mux.Handle("/api/group/edit-group", middleware.AuthMiddleware(http.HandlerFunc(handlers.EditGroup)))
 	mux.Handle("/api/group/delete-group", middleware.AuthMiddleware(http.HandlerFunc(handlers.DeleteGroup)))
+	mux.Handle("GET /api/group/{id}/recipients", middleware.AuthMiddleware(http.HandlerFunc(handlers.ListRecipients)))
+	mux.Handle("POST /api/group/{id}/recipients", middleware.AuthMiddleware(http.HandlerFunc(handlers.AddRecipients)))
+	mux.Handle("DELETE /api/group/{id}/recipients", middleware.AuthMiddleware(http.HandlerFunc(handlers.RemoveRecipients)))
+	mux.Handle("DELETE /api/group/{id}/recipients/{email}", middleware.AuthMiddleware(http.HandlerFunc(handlers.RemoveRecipients)))
+	mux.Handle("POST /api/group/{id}/import/preview", middleware.AuthMiddleware(http.HandlerFunc(handlers.PreviewImport)))
+	mux.Handle("POST /api/group/{id}/import/commit", middleware.AuthMiddleware(http.HandlerFunc(handlers.CommitImport)))
+	mux.Handle("GET /api/group/{id}/preview", middleware.AuthMiddleware(http.HandlerFunc(handlers.PreviewGroup)))
+	mux.Handle("POST /api/group/{id}/test-send", middleware.AuthMiddleware(http.HandlerFunc(handlers.TestSendGroup)))
+	mux.Handle("GET /api/group/{id}/attachments", middleware.AuthMiddleware(http.HandlerFunc(handlers.ListAttachments)))
+	mux.Handle("POST /api/group/{id}/attachments", middleware.AuthMiddleware(http.HandlerFunc(handlers.AddAttachment)))
+	mux.Handle("DELETE /api/group/{id}/attachments/{attachmentID}", middleware.AuthMiddleware(http.HandlerFunc(handlers.DeleteAttachment)))
+	mux.Handle("GET /api/group/{id}/campaigns", middleware.AuthMiddleware(http.HandlerFunc(handlers.ListCampaigns)))
+	mux.Handle("GET /api/group/{id}/campaigns/{campaignID}", middleware.AuthMiddleware(http.HandlerFunc(handlers.GetCampaignStatus)))
+	mux.Handle("PUT /api/group/{id}/campaigns/{campaignID}", middleware.AuthMiddleware(http.HandlerFunc(handlers.RescheduleCampaign)))
+	mux.Handle("DELETE /api/group/{id}/campaigns/{campaignID}", middleware.AuthMiddleware(http.HandlerFunc(handlers.CancelCampaign)))
 }
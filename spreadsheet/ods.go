@@ -0,0 +1,252 @@
+package spreadsheet
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+)
+
+const odsMimeType = "application/vnd.oasis.opendocument.spreadsheet"
+
+const (
+	nsTable  = "urn:oasis:names:tc:opendocument:xmlns:table:1.0"
+	nsOffice = "urn:oasis:names:tc:opendocument:xmlns:office:1.0"
+	nsText   = "urn:oasis:names:tc:opendocument:xmlns:text:1.0"
+)
+
+// maxRepeat caps the spaces a text:s element expands to. Repeated rows and
+// cells count against the sheet's cell budget instead, and empty ones, which
+// pad sheets to their full size, are only expanded when data follows them.
+const maxRepeat = 1000
+
+// readODS streams content.xml, since its tables nest rows inside header
+// and group elements and cells carry their text in paragraphs and spans.
+func readODS(file *zip.File, sheet string) ([][]string, error) {
+	data, err := readEntry(file)
+	if err != nil {
+		return nil, err
+	}
+
+	// The first pass collects the sheet names to resolve the selector.
+	names, err := odsSheetNames(data)
+	if err != nil {
+		return nil, err
+	}
+	index, err := pickSheet(names, sheet)
+	if err != nil {
+		return nil, err
+	}
+
+	decoder := xml.NewDecoder(bytes.NewReader(data))
+	table := -1
+	for {
+		tok, err := decoder.Token()
+		if err != nil {
+			return nil, fmt.Errorf("spreadsheet: invalid content.xml: %v", err)
+		}
+		start, ok := tok.(xml.StartElement)
+		if !ok || start.Name.Space != nsTable || start.Name.Local != "table" {
+			continue
+		}
+		if table++; table == index {
+			return readODSTable(decoder)
+		}
+		decoder.Skip()
+	}
+}
+
+func odsSheetNames(content []byte) ([]string, error) {
+	var names []string
+	decoder := xml.NewDecoder(bytes.NewReader(content))
+	for {
+		tok, err := decoder.Token()
+		if err == io.EOF {
+			return names, nil
+		}
+		if err != nil {
+			return nil, fmt.Errorf("spreadsheet: invalid content.xml: %v", err)
+		}
+		if start, ok := tok.(xml.StartElement); ok && start.Name.Space == nsTable && start.Name.Local == "table" {
+			names = append(names, attr(start, nsTable, "name"))
+			decoder.Skip()
+		}
+	}
+}
+
+func readODSTable(decoder *xml.Decoder) ([][]string, error) {
+	var rows [][]string
+	var budget cellBudget
+	emptyRows := 0
+	for {
+		tok, err := decoder.Token()
+		if err != nil {
+			return nil, fmt.Errorf("spreadsheet: invalid content.xml: %v", err)
+		}
+		switch t := tok.(type) {
+		case xml.StartElement:
+			if t.Name.Space != nsTable {
+				decoder.Skip()
+				continue
+			}
+			switch t.Name.Local {
+			case "table-row":
+				row, err := readODSRow(decoder)
+				if err != nil {
+					return nil, err
+				}
+				n := repeat(t, "number-rows-repeated")
+				if len(row) == 0 {
+					emptyRows += n
+					continue
+				}
+				// Empty rows before data are kept so rows keep their
+				// numbers.
+				if err := budget.spend(emptyRows + n*len(row)); err != nil {
+					return nil, err
+				}
+				for ; emptyRows > 0; emptyRows-- {
+					rows = append(rows, nil)
+				}
+				for ; n > 0; n-- {
+					rows = append(rows, row)
+				}
+			case "table-header-rows", "table-row-group", "table-rows":
+				// Rows inside these are read as if they were direct children.
+			default:
+				decoder.Skip()
+			}
+		case xml.EndElement:
+			if t.Name.Space == nsTable && t.Name.Local == "table" {
+				return trimRows(rows), nil
+			}
+		}
+	}
+}
+
+func readODSRow(decoder *xml.Decoder) ([]string, error) {
+	var row []string
+	emptyCells := 0
+	for {
+		tok, err := decoder.Token()
+		if err != nil {
+			return nil, fmt.Errorf("spreadsheet: invalid content.xml: %v", err)
+		}
+		switch t := tok.(type) {
+		case xml.StartElement:
+			if t.Name.Space != nsTable || (t.Name.Local != "table-cell" && t.Name.Local != "covered-table-cell") {
+				decoder.Skip()
+				continue
+			}
+			value, err := readODSCell(decoder, t)
+			if err != nil {
+				return nil, err
+			}
+			// Empty cells are only expanded when a value follows, so
+			// later cells keep their column and padding at the end of
+			// the row is dropped.
+			n := repeat(t, "number-columns-repeated")
+			if strings.TrimSpace(value) == "" {
+				emptyCells += n
+				continue
+			}
+			if len(row)+emptyCells+n > maxColumns {
+				return nil, fmt.Errorf("%w: more than %d columns", ErrTooLarge, maxColumns)
+			}
+			for ; emptyCells > 0; emptyCells-- {
+				row = append(row, "")
+			}
+			for ; n > 0; n-- {
+				row = append(row, value)
+			}
+		case xml.EndElement:
+			return row, nil
+		}
+	}
+}
+
+// readODSCell returns a cell's value: the raw value for numbers, dates and
+// booleans, the displayed text for everything else.
+func readODSCell(decoder *xml.Decoder, start xml.StartElement) (string, error) {
+	raw := ""
+	switch attr(start, nsOffice, "value-type") {
+	case "float", "percentage", "currency":
+		raw = attr(start, nsOffice, "value")
+	case "date":
+		raw = attr(start, nsOffice, "date-value")
+	case "time":
+		raw = attr(start, nsOffice, "time-value")
+	case "boolean":
+		raw = strings.ToUpper(attr(start, nsOffice, "boolean-value"))
+	}
+
+	var paragraphs []string
+	var text strings.Builder
+	depth := 0
+	for {
+		tok, err := decoder.Token()
+		if err != nil {
+			return "", fmt.Errorf("spreadsheet: invalid content.xml: %v", err)
+		}
+		switch t := tok.(type) {
+		case xml.StartElement:
+			switch {
+			case t.Name.Space == nsOffice && t.Name.Local == "annotation":
+				decoder.Skip()
+			case t.Name.Space == nsText && t.Name.Local == "s":
+				count, err := strconv.Atoi(attr(t, nsText, "c"))
+				if err != nil || count < 1 {
+					count = 1
+				}
+				text.WriteString(strings.Repeat(" ", min(count, maxRepeat)))
+				decoder.Skip()
+			case t.Name.Space == nsText && t.Name.Local == "tab":
+				text.WriteString("\t")
+				decoder.Skip()
+			case t.Name.Space == nsText && t.Name.Local == "line-break":
+				text.WriteString("\n")
+				decoder.Skip()
+			default:
+				depth++
+			}
+		case xml.CharData:
+			if depth > 0 {
+				text.Write(t)
+			}
+		case xml.EndElement:
+			if depth == 0 {
+				if raw != "" {
+					return raw, nil
+				}
+				return strings.Join(paragraphs, "\n"), nil
+			}
+			depth--
+			if depth == 0 && t.Name.Space == nsText && t.Name.Local == "p" {
+				paragraphs = append(paragraphs, text.String())
+				text.Reset()
+			}
+		}
+	}
+}
+
+// repeat reads a repeat count. Anything beyond maxCells is over budget
+// anyway, so the count is clamped there to keep sums of counts in range.
+func repeat(start xml.StartElement, name string) int {
+	n, err := strconv.Atoi(attr(start, nsTable, name))
+	if err != nil || n < 1 {
+		return 1
+	}
+	return min(n, maxCells+1)
+}
+
+func attr(start xml.StartElement, space, local string) string {
+	for _, a := range start.Attr {
+		if a.Name.Space == space && a.Name.Local == local {
+			return a.Value
+		}
+	}
+	return ""
+}
@@ -0,0 +1,192 @@
+// Package spreadsheet reads the cell values of XLSX (Office Open XML) and
+// ODS (OpenDocument) workbooks. Only what a recipient import needs is
+// supported: the text of each cell of one sheet, without formulas, styles
+// or number formats. Both formats are zip archives of XML, so the archive
+// entries are read with a size cap to keep compressed bombs out.
+package spreadsheet
+
+import (
+	"archive/zip"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+)
+
+// maxEntrySize caps how much XML a single archive entry may expand to.
+const maxEntrySize = 64 << 20
+
+// A few KB of XML can describe millions of cells through cell references
+// far to the right or repeated rows and columns, so sheets are limited to
+// maxColumns columns and maxCells cells in total.
+const (
+	maxColumns = 1000
+	maxCells   = 1000000
+)
+
+var (
+	// ErrUnsupported is returned for data that is no XLSX or ODS workbook.
+	ErrUnsupported = errors.New("spreadsheet: not an XLSX or ODS file")
+	// ErrSheetNotFound is returned when the requested sheet doesn't exist.
+	ErrSheetNotFound = errors.New("spreadsheet: sheet not found")
+	// ErrTooLarge is returned for sheets beyond maxColumns or maxCells.
+	ErrTooLarge = errors.New("spreadsheet: sheet is too large")
+)
+
+// IsSpreadsheet reports whether data looks like a zipped workbook rather
+// than plain text such as CSV.
+func IsSpreadsheet(data []byte) bool {
+	return bytes.HasPrefix(data, []byte("PK\x03\x04"))
+}
+
+// Read returns the rows of one sheet of an XLSX or ODS workbook, told apart
+// by content. sheet is the sheet's name or its 1-based position; empty
+// picks the first sheet. Rows keep their cells' positions, so a blank cell
+// in the middle of a row is an empty string.
+func Read(data []byte, sheet string) ([][]string, error) {
+	files, format, err := open(data)
+	if err != nil {
+		return nil, err
+	}
+	if format == "xlsx" {
+		return readXLSX(files, sheet)
+	}
+	return readODS(files["content.xml"], sheet)
+}
+
+// Sheets returns the names of the sheets of an XLSX or ODS workbook, in
+// workbook order.
+func Sheets(data []byte) ([]string, error) {
+	files, format, err := open(data)
+	if err != nil {
+		return nil, err
+	}
+	if format == "xlsx" {
+		workbook, err := readWorkbook(files)
+		if err != nil {
+			return nil, err
+		}
+		return workbook.names(), nil
+	}
+	content, err := readEntry(files["content.xml"])
+	if err != nil {
+		return nil, err
+	}
+	return odsSheetNames(content)
+}
+
+// Format returns "xlsx" or "ods" for a workbook, or ErrUnsupported.
+func Format(data []byte) (string, error) {
+	_, format, err := open(data)
+	return format, err
+}
+
+// open indexes the archive's entries and tells the two formats apart.
+func open(data []byte) (map[string]*zip.File, string, error) {
+	archive, err := zip.NewReader(bytes.NewReader(data), int64(len(data)))
+	if err != nil {
+		return nil, "", ErrUnsupported
+	}
+
+	files := make(map[string]*zip.File, len(archive.File))
+	for _, file := range archive.File {
+		files[file.Name] = file
+	}
+
+	switch {
+	case files["xl/workbook.xml"] != nil:
+		return files, "xlsx", nil
+	case files["content.xml"] != nil:
+		if mimetype, err := readEntry(files["mimetype"]); err == nil && strings.TrimSpace(string(mimetype)) != odsMimeType {
+			return nil, "", ErrUnsupported
+		}
+		return files, "ods", nil
+	}
+	return nil, "", ErrUnsupported
+}
+
+// readEntry reads an archive entry, refusing to expand it beyond
+// maxEntrySize.
+func readEntry(file *zip.File) ([]byte, error) {
+	if file == nil {
+		return nil, fmt.Errorf("spreadsheet: missing archive entry")
+	}
+	r, err := file.Open()
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+
+	data, err := io.ReadAll(io.LimitReader(r, maxEntrySize+1))
+	if err != nil {
+		return nil, err
+	}
+	if len(data) > maxEntrySize {
+		return nil, fmt.Errorf("spreadsheet: %s is too large", file.Name)
+	}
+	return data, nil
+}
+
+// pickSheet matches a sheet selector against the sheet names in workbook
+// order and returns the index of the sheet to read.
+func pickSheet(names []string, sheet string) (int, error) {
+	if len(names) == 0 {
+		return 0, ErrSheetNotFound
+	}
+	sheet = strings.TrimSpace(sheet)
+	if sheet == "" {
+		return 0, nil
+	}
+	for i, name := range names {
+		if strings.EqualFold(strings.TrimSpace(name), sheet) {
+			return i, nil
+		}
+	}
+	if n, err := strconv.Atoi(sheet); err == nil && n >= 1 && n <= len(names) {
+		return n - 1, nil
+	}
+	return 0, fmt.Errorf("%w: %q (available: %s)", ErrSheetNotFound, sheet, strings.Join(names, ", "))
+}
+
+// setCell stores value at column col of a row, padding the row as needed.
+func setCell(row []string, col int, value string) ([]string, error) {
+	if col >= maxColumns {
+		return nil, fmt.Errorf("%w: more than %d columns", ErrTooLarge, maxColumns)
+	}
+	for len(row) <= col {
+		row = append(row, "")
+	}
+	row[col] = value
+	return row, nil
+}
+
+// cellBudget counts the cells a sheet expands to, an empty row counting as
+// one, and fails once there are more than maxCells.
+type cellBudget int
+
+func (b *cellBudget) spend(n int) error {
+	*b += cellBudget(n)
+	if *b > maxCells {
+		return fmt.Errorf("%w: more than %d cells", ErrTooLarge, maxCells)
+	}
+	return nil
+}
+
+// trimRows drops trailing empty cells and rows, which both formats tend to
+// carry for formatted but unused areas.
+func trimRows(rows [][]string) [][]string {
+	for i, row := range rows {
+		end := len(row)
+		for end > 0 && strings.TrimSpace(row[end-1]) == "" {
+			end--
+		}
+		rows[i] = row[:end]
+	}
+	end := len(rows)
+	for end > 0 && len(rows[end-1]) == 0 {
+		end--
+	}
+	return rows[:end]
+}
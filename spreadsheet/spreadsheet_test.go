@@ -0,0 +1,298 @@
+package spreadsheet
+
+import (
+	"archive/zip"
+	"bytes"
+	"errors"
+	"reflect"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+func zipOf(t *testing.T, files ...[2]string) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	w := zip.NewWriter(&buf)
+	for _, f := range files {
+		entry, err := w.Create(f[0])
+		if err != nil {
+			t.Fatal(err)
+		}
+		entry.Write([]byte(f[1]))
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+// xlsxOf builds a workbook with the sheets Recipients and Extra; rows is the
+// sheetData of Recipients.
+func xlsxOf(t *testing.T, rows string) []byte {
+	t.Helper()
+	const main = `xmlns="http://schemas.openxmlformats.org/spreadsheetml/2006/main"`
+	return zipOf(t,
+		[2]string{"xl/workbook.xml", `<workbook ` + main + ` xmlns:r="http://schemas.openxmlformats.org/officeDocument/2006/relationships"><sheets>` +
+			`<sheet name="Recipients" sheetId="1" r:id="rId1"/><sheet name="Extra" sheetId="2" r:id="rId2"/></sheets></workbook>`},
+		[2]string{"xl/_rels/workbook.xml.rels", `<Relationships xmlns="http://schemas.openxmlformats.org/package/2006/relationships">` +
+			`<Relationship Id="rId1" Target="worksheets/sheet1.xml"/><Relationship Id="rId2" Target="/xl/worksheets/sheet2.xml"/></Relationships>`},
+		[2]string{"xl/sharedStrings.xml", `<sst ` + main + `><si><t>shared</t></si><si><r><t>ri</t></r><r><t>ch</t></r></si></sst>`},
+		[2]string{"xl/worksheets/sheet1.xml", `<worksheet ` + main + `><sheetData>` + rows + `</sheetData></worksheet>`},
+		[2]string{"xl/worksheets/sheet2.xml", `<worksheet ` + main + `><sheetData><row r="1"><c r="A1" t="inlineStr"><is><t>extra</t></is></c></row></sheetData></worksheet>`},
+	)
+}
+
+// odsOf builds a workbook with the sheets Recipients and Extra; rows is the
+// content of the Recipients table.
+func odsOf(t *testing.T, rows string) []byte {
+	t.Helper()
+	return zipOf(t,
+		[2]string{"mimetype", odsMimeType},
+		[2]string{"content.xml", `<office:document-content xmlns:office="` + nsOffice + `" xmlns:table="` + nsTable + `" xmlns:text="` + nsText + `">` +
+			`<office:body><office:spreadsheet>` +
+			`<table:table table:name="Recipients">` + rows + `</table:table>` +
+			`<table:table table:name="Extra"><table:table-row><table:table-cell><text:p>extra</text:p></table:table-cell></table:table-row></table:table>` +
+			`</office:spreadsheet></office:body></office:document-content>`},
+	)
+}
+
+func odsCell(value string, repeated int) string {
+	attrs := ""
+	if repeated > 0 {
+		attrs = ` table:number-columns-repeated="` + strconv.Itoa(repeated) + `"`
+	}
+	if value == "" {
+		return `<table:table-cell` + attrs + `/>`
+	}
+	return `<table:table-cell` + attrs + `><text:p>` + value + `</text:p></table:table-cell>`
+}
+
+func odsRow(repeated int, cells ...string) string {
+	attrs := ""
+	if repeated > 0 {
+		attrs = ` table:number-rows-repeated="` + strconv.Itoa(repeated) + `"`
+	}
+	return `<table:table-row` + attrs + `>` + strings.Join(cells, "") + `</table:table-row>`
+}
+
+func TestReadXLSX(t *testing.T) {
+	tests := []struct {
+		name    string
+		rows    string
+		sheet   string
+		want    [][]string
+		wantErr error
+	}{
+		{
+			name: "values and types",
+			rows: `<row r="1"><c r="A1" t="s"><v>0</v></c><c r="B1" t="s"><v>1</v></c><c r="C1"><v>42</v></c><c r="D1" t="b"><v>1</v></c></row>`,
+			want: [][]string{{"shared", "rich", "42", "TRUE"}},
+		},
+		{
+			name: "gaps keep row and column numbers",
+			rows: `<row r="2"><c r="C2"><v>x</v></c></row><row r="4"><c r="A4"><v>y</v></c><c r="B4" s="1"/></row><row r="9"/>`,
+			want: [][]string{nil, {"", "", "x"}, nil, {"y"}},
+		},
+		{
+			name: "cells without references follow each other",
+			rows: `<row><c><v>a</v></c><c><v>b</v></c></row><row><c><v>c</v></c></row>`,
+			want: [][]string{{"a", "b"}, {"c"}},
+		},
+		{
+			name:  "sheet by name",
+			sheet: "extra",
+			want:  [][]string{{"extra"}},
+		},
+		{
+			name:  "sheet by position",
+			sheet: "2",
+			want:  [][]string{{"extra"}},
+		},
+		{
+			name:    "unknown sheet",
+			sheet:   "3",
+			wantErr: ErrSheetNotFound,
+		},
+		{
+			name: "last column",
+			rows: `<row r="1"><c r="ALL1"><v>x</v></c></row>`,
+			want: [][]string{append(make([]string, maxColumns-1), "x")},
+		},
+		{
+			name:    "column beyond the limit",
+			rows:    `<row r="1"><c r="ALM1"><v>x</v></c></row>`,
+			wantErr: ErrTooLarge,
+		},
+		{
+			name:    "far column of a short reference",
+			rows:    `<row r="1"><c r="XFD1"><v>x</v></c></row>`,
+			wantErr: ErrTooLarge,
+		},
+		{
+			name: "last row within the budget",
+			rows: `<row r="1000000"><c r="A1000000"><v>x</v></c></row>`,
+		},
+		{
+			name:    "row beyond the budget",
+			rows:    `<row r="1000001"><c r="A1000001"><v>x</v></c></row>`,
+			wantErr: ErrTooLarge,
+		},
+		{
+			name:    "wide rows add up",
+			rows:    strings.Repeat(`<row><c r="ALL1"><v>x</v></c></row>`, maxCells/maxColumns+1),
+			wantErr: ErrTooLarge,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := Read(xlsxOf(t, tt.rows), tt.sheet)
+			if tt.wantErr != nil {
+				if !errors.Is(err, tt.wantErr) {
+					t.Fatalf("Read error = %v, want %v", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Read: %v", err)
+			}
+			if tt.want != nil && !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Read = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestReadODS(t *testing.T) {
+	tests := []struct {
+		name    string
+		rows    string
+		sheet   string
+		want    [][]string
+		wantErr error
+	}{
+		{
+			name: "values and types",
+			rows: odsRow(0,
+				odsCell("a", 0),
+				`<table:table-cell office:value-type="float" office:value="42"><text:p>42.00</text:p></table:table-cell>`,
+				`<table:table-cell office:value-type="boolean" office:boolean-value="true"><text:p>yes</text:p></table:table-cell>`,
+				`<table:table-cell><text:p>two<text:s text:c="2"/>spaces</text:p><text:p>second</text:p></table:table-cell>`,
+			),
+			want: [][]string{{"a", "42", "TRUE", "two  spaces\nsecond"}},
+		},
+		{
+			name: "repeated cells and rows",
+			rows: odsRow(2, odsCell("x", 3)),
+			want: [][]string{{"x", "x", "x"}, {"x", "x", "x"}},
+		},
+		{
+			name: "empty cells and rows before data are kept",
+			rows: odsRow(2) + odsRow(0, odsCell("", 2), odsCell("x", 0)),
+			want: [][]string{nil, nil, {"", "", "x"}},
+		},
+		{
+			name: "padding is dropped",
+			rows: odsRow(0, odsCell("x", 0), odsCell("", 16383)) + odsRow(1048575, odsCell("", 16384)),
+			want: [][]string{{"x"}},
+		},
+		{
+			name: "rows in header rows and groups",
+			rows: `<table:table-header-rows>` + odsRow(0, odsCell("head", 0)) + `</table:table-header-rows>` +
+				`<table:table-row-group>` + odsRow(0, odsCell("body", 0)) + `</table:table-row-group>`,
+			want: [][]string{{"head"}, {"body"}},
+		},
+		{
+			name:  "sheet by name",
+			sheet: "Extra",
+			want:  [][]string{{"extra"}},
+		},
+		{
+			name:  "sheet by position",
+			sheet: "2",
+			want:  [][]string{{"extra"}},
+		},
+		{
+			name:    "unknown sheet",
+			sheet:   "Missing",
+			wantErr: ErrSheetNotFound,
+		},
+		{
+			name: "columns up to the limit",
+			rows: odsRow(0, odsCell("", maxColumns-1), odsCell("x", 0)),
+			want: [][]string{append(make([]string, maxColumns-1), "x")},
+		},
+		{
+			name:    "repeated value beyond the column limit",
+			rows:    odsRow(0, odsCell("x", maxColumns+1)),
+			wantErr: ErrTooLarge,
+		},
+		{
+			name:    "value after empty cells beyond the column limit",
+			rows:    odsRow(0, odsCell("", maxColumns), odsCell("x", 0)),
+			wantErr: ErrTooLarge,
+		},
+		{
+			name:    "repeated rows beyond the budget",
+			rows:    odsRow(maxCells/maxColumns+1, odsCell("x", maxColumns)),
+			wantErr: ErrTooLarge,
+		},
+		{
+			name:    "value after empty rows beyond the budget",
+			rows:    odsRow(maxCells) + odsRow(0, odsCell("x", 0)),
+			wantErr: ErrTooLarge,
+		},
+		{
+			name:    "huge repeat count",
+			rows:    odsRow(1<<62, odsCell("x", 0)),
+			wantErr: ErrTooLarge,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := Read(odsOf(t, tt.rows), tt.sheet)
+			if tt.wantErr != nil {
+				if !errors.Is(err, tt.wantErr) {
+					t.Fatalf("Read error = %v, want %v", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Read: %v", err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Read = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestFormat(t *testing.T) {
+	tests := []struct {
+		name    string
+		data    []byte
+		want    string
+		wantErr error
+	}{
+		{"xlsx", xlsxOf(t, ""), "xlsx", nil},
+		{"ods", odsOf(t, ""), "ods", nil},
+		{"other zip", zipOf(t, [2]string{"word/document.xml", "<w/>"}), "", ErrUnsupported},
+		{"wrong mimetype", zipOf(t, [2]string{"mimetype", "application/vnd.oasis.opendocument.text"}, [2]string{"content.xml", "<x/>"}), "", ErrUnsupported},
+		{"csv", []byte("email\na@example.com\n"), "", ErrUnsupported},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := Format(tt.data)
+			if got != tt.want || !errors.Is(err, tt.wantErr) {
+				t.Errorf("Format = %q, %v, want %q, %v", got, err, tt.want, tt.wantErr)
+			}
+		})
+	}
+
+	names, err := Sheets(odsOf(t, ""))
+	if err != nil || !reflect.DeepEqual(names, []string{"Recipients", "Extra"}) {
+		t.Errorf("Sheets = %q, %v", names, err)
+	}
+}
@@ -0,0 +1,214 @@
+package spreadsheet
+
+import (
+	"archive/zip"
+	"encoding/xml"
+	"fmt"
+	"path"
+	"strconv"
+	"strings"
+)
+
+type xlsxWorkbook struct {
+	Sheets []struct {
+		Name  string     `xml:"name,attr"`
+		Attrs []xml.Attr `xml:",any,attr"`
+	} `xml:"sheets>sheet"`
+}
+
+type xlsxRelationships struct {
+	Relationships []struct {
+		ID     string `xml:"Id,attr"`
+		Target string `xml:"Target,attr"`
+	} `xml:"Relationship"`
+}
+
+// xlsxText is a shared or inline string: plain text, or rich text split in
+// runs. Phonetic hints (rPh) are left out.
+type xlsxText struct {
+	T string `xml:"t"`
+	R []struct {
+		T string `xml:"t"`
+	} `xml:"r"`
+}
+
+func (t xlsxText) String() string {
+	if len(t.R) == 0 {
+		return t.T
+	}
+	var text strings.Builder
+	for _, run := range t.R {
+		text.WriteString(run.T)
+	}
+	return text.String()
+}
+
+type xlsxSharedStrings struct {
+	Items []xlsxText `xml:"si"`
+}
+
+type xlsxWorksheet struct {
+	Rows []struct {
+		Ref   string `xml:"r,attr"`
+		Cells []struct {
+			Ref    string   `xml:"r,attr"`
+			Type   string   `xml:"t,attr"`
+			Value  string   `xml:"v"`
+			Inline xlsxText `xml:"is"`
+		} `xml:"c"`
+	} `xml:"sheetData>row"`
+}
+
+func readWorkbook(files map[string]*zip.File) (xlsxWorkbook, error) {
+	var workbook xlsxWorkbook
+	err := unmarshalEntry(files["xl/workbook.xml"], &workbook)
+	return workbook, err
+}
+
+func (w xlsxWorkbook) names() []string {
+	names := make([]string, len(w.Sheets))
+	for i, s := range w.Sheets {
+		names[i] = s.Name
+	}
+	return names
+}
+
+func readXLSX(files map[string]*zip.File, sheet string) ([][]string, error) {
+	workbook, err := readWorkbook(files)
+	if err != nil {
+		return nil, err
+	}
+	names := workbook.names()
+	index, err := pickSheet(names, sheet)
+	if err != nil {
+		return nil, err
+	}
+
+	// The sheet's relationship id is namespaced differently in transitional
+	// and strict files, so match it by local name.
+	relID := ""
+	for _, attr := range workbook.Sheets[index].Attrs {
+		if attr.Name.Local == "id" && attr.Name.Space != "" {
+			relID = attr.Value
+		}
+	}
+	var rels xlsxRelationships
+	if err := unmarshalEntry(files["xl/_rels/workbook.xml.rels"], &rels); err != nil {
+		return nil, err
+	}
+	target := ""
+	for _, rel := range rels.Relationships {
+		if rel.ID == relID {
+			target = rel.Target
+		}
+	}
+	if strings.HasPrefix(target, "/") {
+		target = strings.TrimPrefix(target, "/")
+	} else if target != "" {
+		target = path.Join("xl", target)
+	}
+	if files[target] == nil {
+		return nil, fmt.Errorf("spreadsheet: sheet %q has no data", names[index])
+	}
+
+	var shared xlsxSharedStrings
+	if files["xl/sharedStrings.xml"] != nil {
+		if err := unmarshalEntry(files["xl/sharedStrings.xml"], &shared); err != nil {
+			return nil, err
+		}
+	}
+
+	var worksheet xlsxWorksheet
+	if err := unmarshalEntry(files[target], &worksheet); err != nil {
+		return nil, err
+	}
+
+	var budget cellBudget
+	rows := make([][]string, 0, len(worksheet.Rows))
+	for _, r := range worksheet.Rows {
+		// Rows left out of the sheet are empty ones, kept so the rows
+		// keep their numbers.
+		if r.Ref != "" {
+			n, err := strconv.Atoi(r.Ref)
+			if err != nil || n <= len(rows) {
+				return nil, fmt.Errorf("spreadsheet: invalid row reference %q", r.Ref)
+			}
+			if err := budget.spend(n - 1 - len(rows)); err != nil {
+				return nil, err
+			}
+			for len(rows) < n-1 {
+				rows = append(rows, nil)
+			}
+		}
+
+		var row []string
+		col := -1
+		for _, c := range r.Cells {
+			col++
+			if c.Ref != "" {
+				if col = columnIndex(c.Ref); col < 0 {
+					return nil, fmt.Errorf("spreadsheet: invalid cell reference %q", c.Ref)
+				}
+			}
+
+			value := c.Value
+			switch c.Type {
+			case "s":
+				n, err := strconv.Atoi(strings.TrimSpace(c.Value))
+				if err != nil || n < 0 || n >= len(shared.Items) {
+					return nil, fmt.Errorf("spreadsheet: invalid shared string in %s", c.Ref)
+				}
+				value = shared.Items[n].String()
+			case "inlineStr":
+				value = c.Inline.String()
+			case "b":
+				value = map[string]string{"1": "TRUE", "0": "FALSE"}[c.Value]
+			}
+			// Empty cells, often only there for their style, don't pad
+			// the row.
+			if value == "" {
+				continue
+			}
+			if row, err = setCell(row, col, value); err != nil {
+				return nil, err
+			}
+		}
+		if err := budget.spend(max(len(row), 1)); err != nil {
+			return nil, err
+		}
+		rows = append(rows, row)
+	}
+	return trimRows(rows), nil
+}
+
+func unmarshalEntry(file *zip.File, v interface{}) error {
+	data, err := readEntry(file)
+	if err != nil {
+		return err
+	}
+	if err := xml.Unmarshal(data, v); err != nil {
+		return fmt.Errorf("spreadsheet: invalid %s: %v", file.Name, err)
+	}
+	return nil
+}
+
+// columnIndex turns the letters of a cell reference ("AB12") into a
+// 0-based column index, or -1 when there are none.
+func columnIndex(ref string) int {
+	col := 0
+	letters := 0
+	for _, r := range strings.ToUpper(ref) {
+		if r < 'A' || r > 'Z' {
+			break
+		}
+		col = col*26 + int(r-'A'+1)
+		letters++
+		if letters > 3 {
+			return -1
+		}
+	}
+	if letters == 0 {
+		return -1
+	}
+	return col - 1
+}
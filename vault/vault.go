@@ -0,0 +1,247 @@
+// Package vault envelope-encrypts secrets such as SMTP passwords before they
+// are stored. Every secret gets its own random data key (AES-256-GCM), and
+// that data key is wrapped with a master key from configuration. Rotating
+// the master key only re-wraps the data keys; see Rewrap.
+//
+// Master keys are configured as vaultMasterKeys="id:base64key,..." (32 byte
+// keys) and new secrets are sealed with vaultActiveKey, which defaults to
+// the last key listed. Older keys stay listed until every secret has been
+// re-wrapped.
+package vault
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"os"
+	"regexp"
+	"strings"
+	"sync"
+)
+
+const prefix = "vault:v1:"
+
+var (
+	// ErrNotConfigured is returned when no master key is configured.
+	ErrNotConfigured = errors.New("vault: no master key configured")
+
+	keyIDPattern = regexp.MustCompile(`^[A-Za-z0-9_-]+$`)
+)
+
+// Vault seals and opens secrets with a set of master keys.
+type Vault struct {
+	keys   map[string][]byte
+	active string
+}
+
+// New returns a vault sealing with the active key. keys maps key IDs to
+// 32 byte master keys.
+func New(keys map[string][]byte, active string) (*Vault, error) {
+	if len(keys) == 0 {
+		return nil, ErrNotConfigured
+	}
+	for id, key := range keys {
+		if !keyIDPattern.MatchString(id) {
+			return nil, fmt.Errorf("vault: invalid key id %q", id)
+		}
+		if len(key) != 32 {
+			return nil, fmt.Errorf("vault: key %q must be 32 bytes, got %d", id, len(key))
+		}
+	}
+	if _, ok := keys[active]; !ok {
+		return nil, fmt.Errorf("vault: active key %q is not configured", active)
+	}
+	return &Vault{keys: keys, active: active}, nil
+}
+
+// FromEnv builds the vault from vaultMasterKeys and vaultActiveKey.
+func FromEnv() (*Vault, error) {
+	spec := strings.TrimSpace(os.Getenv("vaultMasterKeys"))
+	if spec == "" {
+		return nil, ErrNotConfigured
+	}
+
+	keys := make(map[string][]byte)
+	active := ""
+	for _, entry := range strings.Split(spec, ",") {
+		id, encoded, ok := strings.Cut(strings.TrimSpace(entry), ":")
+		if !ok {
+			return nil, fmt.Errorf("vault: master keys must look like id:base64key")
+		}
+		key, err := base64.StdEncoding.DecodeString(encoded)
+		if err != nil {
+			return nil, fmt.Errorf("vault: key %q is not valid base64", id)
+		}
+		keys[id] = key
+		active = id
+	}
+	if id := strings.TrimSpace(os.Getenv("vaultActiveKey")); id != "" {
+		active = id
+	}
+	return New(keys, active)
+}
+
+var (
+	defaultOnce  sync.Once
+	defaultVault *Vault
+	defaultErr   error
+)
+
+// Default returns the vault configured through the environment.
+func Default() (*Vault, error) {
+	defaultOnce.Do(func() {
+		defaultVault, defaultErr = FromEnv()
+	})
+	return defaultVault, defaultErr
+}
+
+// Seal encrypts a secret with the default vault.
+func Seal(plaintext string) (string, error) {
+	v, err := Default()
+	if err != nil {
+		return "", err
+	}
+	return v.Seal(plaintext)
+}
+
+// Open decrypts a secret sealed by the default vault.
+func Open(sealed string) (string, error) {
+	v, err := Default()
+	if err != nil {
+		return "", err
+	}
+	return v.Open(sealed)
+}
+
+// IsSealed reports whether s was produced by Seal.
+func IsSealed(s string) bool {
+	return strings.HasPrefix(s, prefix)
+}
+
+// Seal encrypts plaintext under a fresh data key wrapped with the active
+// master key. The result is "vault:v1:<key id>:<wrapped data key>:<data>".
+func (v *Vault) Seal(plaintext string) (string, error) {
+	dataKey := make([]byte, 32)
+	if _, err := rand.Read(dataKey); err != nil {
+		return "", err
+	}
+
+	data, err := encrypt(dataKey, []byte(plaintext), nil)
+	if err != nil {
+		return "", err
+	}
+	wrapped, err := encrypt(v.keys[v.active], dataKey, []byte(v.active))
+	if err != nil {
+		return "", err
+	}
+
+	return prefix + v.active + ":" + base64.RawURLEncoding.EncodeToString(wrapped) + ":" + base64.RawURLEncoding.EncodeToString(data), nil
+}
+
+// Open decrypts a sealed secret with whichever configured master key it was
+// wrapped with.
+func (v *Vault) Open(sealed string) (string, error) {
+	keyID, wrapped, data, err := parse(sealed)
+	if err != nil {
+		return "", err
+	}
+	dataKey, err := v.unwrap(keyID, wrapped)
+	if err != nil {
+		return "", err
+	}
+
+	plaintext, err := decrypt(dataKey, data, nil)
+	if err != nil {
+		return "", fmt.Errorf("vault: secret is corrupted")
+	}
+	return string(plaintext), nil
+}
+
+// Rewrap re-wraps the data key of a sealed secret with the active master
+// key, leaving the encrypted secret itself untouched. It reports whether
+// anything changed.
+func (v *Vault) Rewrap(sealed string) (string, bool, error) {
+	keyID, wrapped, data, err := parse(sealed)
+	if err != nil {
+		return "", false, err
+	}
+	if keyID == v.active {
+		return sealed, false, nil
+	}
+
+	dataKey, err := v.unwrap(keyID, wrapped)
+	if err != nil {
+		return "", false, err
+	}
+	rewrapped, err := encrypt(v.keys[v.active], dataKey, []byte(v.active))
+	if err != nil {
+		return "", false, err
+	}
+
+	return prefix + v.active + ":" + base64.RawURLEncoding.EncodeToString(rewrapped) + ":" + base64.RawURLEncoding.EncodeToString(data), true, nil
+}
+
+func (v *Vault) unwrap(keyID string, wrapped []byte) ([]byte, error) {
+	masterKey, ok := v.keys[keyID]
+	if !ok {
+		return nil, fmt.Errorf("vault: master key %q is not configured", keyID)
+	}
+	dataKey, err := decrypt(masterKey, wrapped, []byte(keyID))
+	if err != nil {
+		return nil, fmt.Errorf("vault: data key does not open with master key %q", keyID)
+	}
+	return dataKey, nil
+}
+
+func parse(sealed string) (keyID string, wrapped, data []byte, err error) {
+	if !IsSealed(sealed) {
+		return "", nil, nil, fmt.Errorf("vault: value is not sealed")
+	}
+	parts := strings.Split(strings.TrimPrefix(sealed, prefix), ":")
+	if len(parts) != 3 {
+		return "", nil, nil, fmt.Errorf("vault: malformed sealed value")
+	}
+	if wrapped, err = base64.RawURLEncoding.DecodeString(parts[1]); err != nil {
+		return "", nil, nil, fmt.Errorf("vault: malformed sealed value")
+	}
+	if data, err = base64.RawURLEncoding.DecodeString(parts[2]); err != nil {
+		return "", nil, nil, fmt.Errorf("vault: malformed sealed value")
+	}
+	return parts[0], wrapped, data, nil
+}
+
+// encrypt returns nonce || AES-256-GCM ciphertext.
+func encrypt(key, plaintext, additional []byte) ([]byte, error) {
+	gcm, err := newGCM(key)
+	if err != nil {
+		return nil, err
+	}
+	nonce := make([]byte, gcm.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+	return gcm.Seal(nonce, nonce, plaintext, additional), nil
+}
+
+func decrypt(key, ciphertext, additional []byte) ([]byte, error) {
+	gcm, err := newGCM(key)
+	if err != nil {
+		return nil, err
+	}
+	if len(ciphertext) < gcm.NonceSize() {
+		return nil, fmt.Errorf("vault: ciphertext too short")
+	}
+	nonce, sealed := ciphertext[:gcm.NonceSize()], ciphertext[gcm.NonceSize():]
+	return gcm.Open(nil, nonce, sealed, additional)
+}
+
+func newGCM(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
@@ -0,0 +1,187 @@
+package vault
+
+import (
+	"bytes"
+	"encoding/base64"
+	"strings"
+	"testing"
+)
+
+func testKey(b byte) []byte {
+	return bytes.Repeat([]byte{b}, 32)
+}
+
+func mustNew(t *testing.T, keys map[string][]byte, active string) *Vault {
+	t.Helper()
+	v, err := New(keys, active)
+	if err != nil {
+		t.Fatalf("New: %v", err)
+	}
+	return v
+}
+
+func TestSealOpen(t *testing.T) {
+	v := mustNew(t, map[string][]byte{"k1": testKey(1)}, "k1")
+
+	for _, secret := range []string{"", "hunter2", "pässwörd with spaces", strings.Repeat("x", 4096)} {
+		sealed, err := v.Seal(secret)
+		if err != nil {
+			t.Fatalf("Seal(%q): %v", secret, err)
+		}
+		if !IsSealed(sealed) || !strings.HasPrefix(sealed, "vault:v1:k1:") {
+			t.Errorf("Seal(%q) = %q, want a value sealed with k1", secret, sealed)
+		}
+		if secret != "" && strings.Contains(sealed, secret) {
+			t.Errorf("Seal(%q) leaks the secret", secret)
+		}
+		got, err := v.Open(sealed)
+		if err != nil || got != secret {
+			t.Errorf("Open(Seal(%q)) = %q, %v", secret, got, err)
+		}
+	}
+
+	a, _ := v.Seal("same")
+	b, _ := v.Seal("same")
+	if a == b {
+		t.Error("sealing the same secret twice gave the same value")
+	}
+}
+
+func TestOpenRejects(t *testing.T) {
+	v := mustNew(t, map[string][]byte{"k1": testKey(1)}, "k1")
+	sealed, err := v.Seal("hunter2")
+	if err != nil {
+		t.Fatal(err)
+	}
+	parts := strings.Split(strings.TrimPrefix(sealed, prefix), ":")
+
+	flip := func(s string) string {
+		data, _ := base64.RawURLEncoding.DecodeString(s)
+		data[len(data)-1] ^= 1
+		return base64.RawURLEncoding.EncodeToString(data)
+	}
+
+	tests := []struct {
+		name   string
+		sealed string
+	}{
+		{"plaintext", "hunter2"},
+		{"malformed", prefix + "k1:abc"},
+		{"bad base64", prefix + "k1:!!:" + parts[2]},
+		{"unknown key", prefix + "k9:" + parts[1] + ":" + parts[2]},
+		{"key id swapped", prefix + "k2:" + parts[1] + ":" + parts[2]},
+		{"tampered data key", prefix + "k1:" + flip(parts[1]) + ":" + parts[2]},
+		{"tampered data", prefix + "k1:" + parts[1] + ":" + flip(parts[2])},
+	}
+
+	other := mustNew(t, map[string][]byte{"k1": testKey(1), "k2": testKey(2)}, "k1")
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got, err := other.Open(tt.sealed); err == nil {
+				t.Errorf("Open(%q) = %q, want an error", tt.sealed, got)
+			}
+		})
+	}
+
+	wrongKey := mustNew(t, map[string][]byte{"k1": testKey(9)}, "k1")
+	if _, err := wrongKey.Open(sealed); err == nil {
+		t.Error("Open with a different master key under the same id succeeded")
+	}
+}
+
+func TestRewrap(t *testing.T) {
+	old := mustNew(t, map[string][]byte{"k1": testKey(1)}, "k1")
+	sealed, err := old.Seal("hunter2")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rotated := mustNew(t, map[string][]byte{"k1": testKey(1), "k2": testKey(2)}, "k2")
+	if got, err := rotated.Open(sealed); err != nil || got != "hunter2" {
+		t.Fatalf("Open with the retired key still listed = %q, %v", got, err)
+	}
+
+	rewrapped, changed, err := rotated.Rewrap(sealed)
+	if err != nil || !changed {
+		t.Fatalf("Rewrap = %v, %v, want a change", changed, err)
+	}
+	if !strings.HasPrefix(rewrapped, prefix+"k2:") {
+		t.Errorf("Rewrap = %q, want it wrapped with k2", rewrapped)
+	}
+	if strings.Split(rewrapped, ":")[4] != strings.Split(sealed, ":")[4] {
+		t.Error("Rewrap re-encrypted the secret instead of only its data key")
+	}
+
+	if again, changed, err := rotated.Rewrap(rewrapped); err != nil || changed || again != rewrapped {
+		t.Errorf("Rewrap of a current value = %q, %v, %v, want it unchanged", again, changed, err)
+	}
+
+	retired := mustNew(t, map[string][]byte{"k2": testKey(2)}, "k2")
+	if got, err := retired.Open(rewrapped); err != nil || got != "hunter2" {
+		t.Errorf("Open after removing the retired key = %q, %v", got, err)
+	}
+	if _, err := retired.Open(sealed); err == nil {
+		t.Error("Open of a value still wrapped with the removed key succeeded")
+	}
+	if _, _, err := retired.Rewrap(sealed); err == nil {
+		t.Error("Rewrap of a value wrapped with the removed key succeeded")
+	}
+}
+
+func TestNewErrors(t *testing.T) {
+	tests := []struct {
+		name   string
+		keys   map[string][]byte
+		active string
+	}{
+		{"no keys", nil, ""},
+		{"short key", map[string][]byte{"k1": testKey(1)[:16]}, "k1"},
+		{"invalid id", map[string][]byte{"k:1": testKey(1)}, "k:1"},
+		{"unknown active key", map[string][]byte{"k1": testKey(1)}, "k2"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := New(tt.keys, tt.active); err == nil {
+				t.Error("New succeeded, want an error")
+			}
+		})
+	}
+}
+
+func TestFromEnv(t *testing.T) {
+	k1 := base64.StdEncoding.EncodeToString(testKey(1))
+	k2 := base64.StdEncoding.EncodeToString(testKey(2))
+
+	tests := []struct {
+		name       string
+		keys       string
+		active     string
+		wantActive string
+		wantErr    bool
+	}{
+		{"last key is active", "k1:" + k1 + ", k2:" + k2, "", "k2", false},
+		{"active key chosen", "k1:" + k1 + ",k2:" + k2, "k1", "k1", false},
+		{"not configured", "", "", "", true},
+		{"missing id", k1, "", "", true},
+		{"bad base64", "k1:not-base64!", "", "", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Setenv("vaultMasterKeys", tt.keys)
+			t.Setenv("vaultActiveKey", tt.active)
+			v, err := FromEnv()
+			if tt.wantErr {
+				if err == nil {
+					t.Error("FromEnv succeeded, want an error")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("FromEnv: %v", err)
+			}
+			if v.active != tt.wantActive {
+				t.Errorf("active key = %q, want %q", v.active, tt.wantActive)
+			}
+		})
+	}
+}